@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"equity-server/internal/fakefinnhub"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	latency := flag.Duration("latency", 0, "delay added to every REST response")
+	tradeInterval := flag.Duration("trade-interval", time.Second, "interval between generated trades per subscribed symbol (0 disables)")
+	flag.Parse()
+
+	server := fakefinnhub.New()
+	server.SetLatency(*latency)
+
+	if *tradeInterval > 0 {
+		go generateTrades(server, *tradeInterval)
+	}
+
+	log.Printf("Fake Finnhub listening on %s", *addr)
+	log.Printf("  REST base URL: http://localhost%s%s", *addr, fakefinnhub.APIPrefix)
+	log.Printf("  Trade feed:    ws://localhost%s%s", *addr, fakefinnhub.StreamPath)
+	log.Printf("  Control:       POST /_fake/program, /_fake/latency, /_fake/trade, /_fake/reset; GET /_fake/requests")
+
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatalf("Fake Finnhub server failed: %v", err)
+	}
+}
+
+// generateTrades random-walks a price for every subscribed symbol
+func generateTrades(server *fakefinnhub.Server, interval time.Duration) {
+	prices := make(map[string]float64)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, symbol := range server.StreamSubscribers() {
+			price, ok := prices[symbol]
+			if !ok {
+				price = fakefinnhub.SymbolPrice(symbol)
+			}
+			price *= 1 + rand.NormFloat64()*0.001
+			prices[symbol] = price
+
+			server.PublishTrade(symbol, price, float64(1+rand.Intn(500)))
+		}
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // cache warm schedules need zone data on minimal images
 
 	"equity-server/internal/cache"
 	"equity-server/internal/clients"
@@ -15,6 +18,7 @@ import (
 	"equity-server/internal/handlers"
 	"equity-server/internal/hub"
 	"equity-server/internal/middleware"
+	"equity-server/internal/warmer"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -31,22 +35,140 @@ func main() {
 	cfg := config.Load()
 
 	// Initialize cache
+	evictionPolicy, err := cache.ParseEvictionPolicy(cfg.Cache.MemoryEviction)
+	if err != nil {
+		log.Fatalf("Invalid cache configuration: %v", err)
+	}
+
 	var cacheClient cache.Cache
+	var snapshotter *cache.Snapshotter
 	redisCache, err := cache.NewRedisCache(cfg.Redis)
 	if err != nil {
 		log.Printf("Failed to connect to Redis, using in-memory cache: %v", err)
-		cacheClient = cache.NewMemoryCache()
+		memoryCache := cache.NewMemoryCache(cache.MemoryCacheOptions{
+			MaxEntries: cfg.Cache.MemoryMaxEntries,
+			MaxBytes:   cfg.Cache.MemoryMaxBytes,
+			Policy:     evictionPolicy,
+		})
+		if cfg.Cache.SnapshotPath != "" {
+			// A bad snapshot only costs a cold cache
+			if loaded, err := memoryCache.LoadSnapshot(cfg.Cache.SnapshotPath); err != nil {
+				log.Printf("Ignoring cache snapshot: %v", err)
+			} else if loaded > 0 {
+				log.Printf("Loaded %d cache entries from %s", loaded, cfg.Cache.SnapshotPath)
+			}
+			snapshotter = cache.NewSnapshotter(memoryCache, cfg.Cache.SnapshotPath, cfg.Cache.SnapshotInterval)
+			go snapshotter.Run()
+		}
+		cacheClient = memoryCache
+	} else if cfg.Cache.L1Enabled {
+		l1 := cache.NewMemoryCache(cache.MemoryCacheOptions{
+			MaxEntries: cfg.Cache.L1MaxEntries,
+			Policy:     evictionPolicy,
+		})
+		tieredCache := cache.NewTieredCache(l1, redisCache, cfg.Cache.L1TTL, cfg.Cache.L1InvalidationChannel)
+		defer tieredCache.Close()
+		cacheClient = tieredCache
 	} else {
 		cacheClient = redisCache
 	}
 
-	// Initialize Finnhub client
-	finnhubClient := clients.NewFinnhubClient(cfg.FinnhubAPIKey, cacheClient)
+	// Versioned keys and encoding for cached market data
+	codec, err := cache.ParseCodec(cfg.Cache.Codec)
+	if err != nil {
+		log.Fatalf("Invalid cache configuration: %v", err)
+	}
+	serializer, err := cache.NewSerializer(codec, cfg.Cache.CompressAbove)
+	if err != nil {
+		log.Fatalf("Invalid cache configuration: %v", err)
+	}
+	cacheStore := cache.NewStore(cacheClient, cfg.Cache.Namespace, serializer)
+
+	// Finnhub API keys, shared by the REST client and the trade stream.
+	// Replayed fixtures need no real key; otherwise Finnhub can't be
+	// reached without one, so simulated data is served in its place.
+	if len(cfg.Finnhub.APIKeys) == 0 {
+		if cfg.Finnhub.FixtureMode == clients.FixtureModeReplay {
+			cfg.Finnhub.APIKeys = []string{"replay"}
+		} else {
+			cfg.MarketData.Providers = replaceFinnhub(cfg.MarketData.Providers)
+		}
+	}
+	keyPool := clients.NewKeyPool(cfg.Finnhub.APIKeys, cfg.Finnhub.KeyRatePerMinute, cfg.Finnhub.KeyBenchDuration)
+
+	// Initialize market data providers behind a failover chain
+	backends := make([]clients.ProviderBackend, 0, len(cfg.MarketData.Providers))
+	for _, name := range cfg.MarketData.Providers {
+		backend, err := newMarketDataProvider(name, cfg, cacheStore, keyPool)
+		if err != nil {
+			log.Fatalf("Failed to initialize market data provider: %v", err)
+		}
+		backends = append(backends, clients.ProviderBackend{Name: name, Provider: backend})
+	}
+	provider := clients.NewFailoverProvider(backends, cfg.MarketData.FailoverCooldown)
+	log.Printf("Using market data providers: %s", strings.Join(cfg.MarketData.Providers, ", "))
 
 	// Initialize WebSocket hub
-	wsHub := hub.NewHub(finnhubClient, cacheClient)
+	slowClientPolicy, err := hub.ParseSlowClientPolicy(cfg.Hub.SlowClientPolicy)
+	if err != nil {
+		log.Fatalf("Invalid hub configuration: %v", err)
+	}
+	wsHub := hub.NewHub(provider, cacheClient)
+	wsHub.SetMaxSubscriptions(cfg.Hub.MaxSubscriptions)
+	wsHub.SetChannelInterval(hub.ChannelQuotes, cfg.Hub.QuotesInterval)
+	wsHub.SetChannelInterval(hub.ChannelTrades, cfg.Hub.TradesInterval)
+	wsHub.SetChannelInterval(hub.ChannelOrderBook, cfg.Hub.OrderBookInterval)
+	wsHub.SetChannelInterval(hub.ChannelCandles, cfg.Hub.CandlesInterval)
+	wsHub.SetChannelInterval(hub.ChannelNews, cfg.Hub.NewsInterval)
+	wsHub.SetReplay(cfg.Hub.ReplaySize, cfg.Hub.ReplayRetention)
+	wsHub.SetSlowClientPolicy(slowClientPolicy, cfg.Hub.DisconnectAfter)
+	wsHub.SetCompression(cfg.Hub.CompressAbove)
+
+	// Share upstream polling with other instances through Redis
+	clustered := redisCache != nil && cfg.Hub.ClusterEnabled
+	if clustered {
+		wsHub.SetCluster(hub.NewCluster(redisCache.Client(), hub.ClusterOptions{
+			Channel:   cfg.Hub.ClusterChannel,
+			LeaderKey: cfg.Hub.ClusterLeaderKey,
+			DemandKey: cfg.Hub.ClusterDemandKey,
+			LeaseTTL:  cfg.Hub.ClusterLeaseTTL,
+		}))
+	}
+
+	// Attach a live trade stream from the primary provider when it has
+	// one. In a cluster the hub starts it once this instance leads.
+	stream := newTradeStream(cfg, backends[0], keyPool)
+	if stream != nil {
+		wsHub.SetTradeStream(stream)
+		if !clustered {
+			go stream.Run()
+		}
+	}
+
 	go wsHub.Run()
 
+	// Warm the cache for watchlisted and popular symbols
+	var cacheWarmer *warmer.Warmer
+	if cfg.Warm.Enabled {
+		location, err := time.LoadLocation(cfg.Warm.Timezone)
+		if err != nil {
+			log.Fatalf("Invalid cache warm timezone: %v", err)
+		}
+		cacheWarmer, err = warmer.New(provider, wsHub, warmer.Options{
+			Symbols:       cfg.Warm.Symbols,
+			TopSubscribed: cfg.Warm.TopSubscribed,
+			Interval:      cfg.Warm.Interval,
+			DailyAt:       cfg.Warm.DailyAt,
+			Location:      location,
+			RatePerMinute: cfg.Warm.RatePerMinute,
+			CandleDays:    cfg.Warm.CandleDays,
+		})
+		if err != nil {
+			log.Fatalf("Invalid cache warm configuration: %v", err)
+		}
+		go cacheWarmer.Run()
+	}
+
 	// Setup Gin router
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -69,9 +191,10 @@ func main() {
 	router.Use(rateLimiter.Middleware())
 
 	// Initialize handlers
-	stockHandler := handlers.NewStockHandler(finnhubClient, cacheClient)
+	stockHandler := handlers.NewStockHandler(provider, cacheClient)
 	wsHandler := handlers.NewWebSocketHandler(wsHub)
 	ollamaHandler := handlers.NewOllamaHandler()
+	adminHandler := handlers.NewAdminHandler(keyPool, cacheClient, cacheStore, cacheWarmer, wsHub)
 
 	// API routes
 	api := router.Group("/api/v1")
@@ -104,14 +227,38 @@ func main() {
 		{
 			ollama.GET("/models", ollamaHandler.GetModels)
 		}
+
+		// Admin endpoints
+		if cfg.AdminToken == "" {
+			log.Println("ADMIN_TOKEN not set, admin endpoints are disabled")
+		}
+		admin := api.Group("/admin", middleware.AdminAuth(cfg.AdminToken))
+		{
+			admin.GET("/finnhub/keys", adminHandler.GetFinnhubKeys)
+			admin.GET("/cache/stats", adminHandler.GetCacheStats)
+			admin.GET("/cache/keys", adminHandler.GetCacheKeys)
+			admin.DELETE("/cache/symbols/:symbol", adminHandler.FlushCacheSymbol)
+			admin.DELETE("/cache/families/:family", adminHandler.FlushCacheFamily)
+			admin.GET("/cache/warm", adminHandler.GetCacheWarmStatus)
+			admin.POST("/cache/warm", adminHandler.TriggerCacheWarm)
+			admin.GET("/ws/clients", adminHandler.GetWebSocketClients)
+		}
 	}
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
+		health := gin.H{
 			"status": "healthy",
 			"timestamp": time.Now(),
-		})
+			"providers": provider.Health(),
+		}
+		switch cacheImpl := cacheClient.(type) {
+		case *cache.TieredCache:
+			health["cache"] = cacheImpl.Tiers()
+		case *cache.MemoryCache:
+			health["cache"] = cacheImpl.Usage()
+		}
+		c.JSON(http.StatusOK, health)
 	})
 
 	// Serve static files for frontend
@@ -151,8 +298,21 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Shutdown WebSocket hub
+	// Stop warming before the hub it reads subscriptions from
+	if cacheWarmer != nil {
+		cacheWarmer.Shutdown()
+	}
+
+	// Shutdown WebSocket hub and upstream stream
 	wsHub.Shutdown()
+	if stream != nil {
+		stream.Shutdown()
+	}
+
+	// Save the in-memory cache for the next start
+	if snapshotter != nil {
+		snapshotter.Shutdown()
+	}
 
 	// Shutdown HTTP server
 	if err := srv.Shutdown(ctx); err != nil {
@@ -160,4 +320,64 @@ func main() {
 	}
 
 	log.Println("Server exited")
-}
\ No newline at end of file
+}
+
+// replaceFinnhub swaps Finnhub for the simulator in a provider chain, for
+// when there is no API key to reach Finnhub with
+func replaceFinnhub(names []string) []string {
+	replaced := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == clients.ProviderFinnhub {
+			log.Println("WARNING: FINNHUB_API_KEY and FINNHUB_API_KEYS are not set, serving simulated market data instead of Finnhub")
+			name = clients.ProviderSimulator
+		}
+		if !seen[name] {
+			seen[name] = true
+			replaced = append(replaced, name)
+		}
+	}
+	return replaced
+}
+
+// newMarketDataProvider builds a single named market data provider
+func newMarketDataProvider(name string, cfg *config.Config, cacheStore *cache.Store, keyPool *clients.KeyPool) (clients.MarketDataProvider, error) {
+	switch name {
+	case clients.ProviderFinnhub:
+		transport, err := clients.NewFixtureTransport(cfg.Finnhub.FixtureMode, cfg.Finnhub.FixtureDir)
+		if err != nil {
+			return nil, err
+		}
+		if transport != nil {
+			log.Printf("Finnhub fixtures: %s mode in %s", cfg.Finnhub.FixtureMode, cfg.Finnhub.FixtureDir)
+		}
+		breaker := clients.NewCircuitBreaker(clients.BreakerSettings{
+			FailureThreshold: cfg.Finnhub.BreakerFailures,
+			OpenTimeout:      cfg.Finnhub.BreakerOpenTimeout,
+			HalfOpenProbes:   cfg.Finnhub.BreakerHalfOpenProbes,
+		})
+		return clients.NewFinnhubClient(keyPool, cfg.Finnhub.BaseURL, cacheStore, transport, breaker), nil
+	case clients.ProviderSimulator:
+		return clients.NewSimulatorProvider(cfg.MarketData.SimulatorSeed, cfg.MarketData.SimulatorTick), nil
+	default:
+		return nil, fmt.Errorf("unknown market data provider %q", name)
+	}
+}
+
+// newTradeStream builds the live trade stream for a provider backend, or
+// returns nil when streaming is unavailable or disabled
+func newTradeStream(cfg *config.Config, backend clients.ProviderBackend, keyPool *clients.KeyPool) clients.TradeStream {
+	// Providers that generate their own trades stream them directly
+	if stream, ok := backend.Provider.(clients.TradeStream); ok {
+		return stream
+	}
+
+	switch backend.Name {
+	case clients.ProviderFinnhub:
+		// Replay runs fully offline, so the live feed stays off
+		if cfg.Finnhub.StreamEnabled && cfg.Finnhub.FixtureMode != clients.FixtureModeReplay {
+			return clients.NewFinnhubStream(cfg.Finnhub.StreamURL, keyPool.Primary())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec encodes cached values
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Codecs a Serializer can write. A payload's header records its codec by
+// position in this list, so new codecs are only ever appended.
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = newMsgpackCodec()
+
+	codecs = []Codec{JSON, MessagePack}
+)
+
+// ParseCodec looks up a codec by name
+func ParseCodec(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cache codec %q", name)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec encodes MessagePack, reading field names from json tags so
+// models need no extra tags
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func newMsgpackCodec() msgpackCodec {
+	handle := &codec.MsgpackHandle{}
+	handle.WriteExt = true
+	return msgpackCodec{handle: handle}
+}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (m msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, m.handle).Encode(v)
+	return data, err
+}
+
+func (m msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, m.handle).Decode(v)
+}
+
+// payloadGzip marks a gzipped body in a payload header
+const payloadGzip byte = 0x80
+
+// Serializer frames cached values as a one-byte header, naming the codec
+// and whether the body is gzipped, followed by the body. It decodes
+// payloads from any codec, so instances configured differently can share a
+// cache.
+type Serializer struct {
+	codec         Codec
+	header        byte
+	compressAbove int
+}
+
+// NewSerializer creates a serializer writing with codec and gzipping bodies
+// larger than compressAbove bytes. Zero disables compression.
+func NewSerializer(c Codec, compressAbove int) (*Serializer, error) {
+	for i, known := range codecs {
+		if known.Name() == c.Name() {
+			return &Serializer{codec: c, header: byte(i + 1), compressAbove: compressAbove}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cache codec %q", c.Name())
+}
+
+// Encode frames v with the serializer's codec
+func (s *Serializer) Encode(v interface{}) ([]byte, error) {
+	body, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	header := s.header
+	if s.compressAbove > 0 && len(body) > s.compressAbove {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+		header |= payloadGzip
+	}
+
+	return append([]byte{header}, body...), nil
+}
+
+// Decode reads a payload written by any Serializer into v. Anything it
+// cannot read fails with a *CacheError.
+func (s *Serializer) Decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return badPayload(fmt.Errorf("empty payload"))
+	}
+
+	header, body := data[0], data[1:]
+	index := int(header&^payloadGzip) - 1
+	if index < 0 || index >= len(codecs) {
+		return badPayload(fmt.Errorf("unknown payload header %#x", header))
+	}
+
+	if header&payloadGzip != 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return badPayload(err)
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return badPayload(err)
+		}
+	}
+
+	if err := codecs[index].Unmarshal(body, v); err != nil {
+		return badPayload(err)
+	}
+	return nil
+}
+
+func badPayload(err error) error {
+	return &CacheError{Message: "malformed cache payload", Err: err}
+}
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,6 +13,117 @@ type Cache interface {
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
+
+	// Keys lists up to limit live keys starting with prefix, in no
+	// particular order
+	Keys(ctx context.Context, prefix string, limit int) ([]string, error)
+
+	// DeletePrefix removes every key starting with prefix and returns how
+	// many were removed
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+
+	// Stats returns hit and miss counts, overall and per key family
+	Stats() Stats
+}
+
+// HitStats counts cache lookups
+type HitStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+
+	// Lookups that found a payload that failed to decode, counted as misses
+	BadPayloads int64 `json:"badPayloads,omitempty"`
+}
+
+// Stats is a snapshot of a cache's lookups, overall and per key family
+type Stats struct {
+	Backend string `json:"backend"`
+	HitStats
+	Families map[string]HitStats `json:"families"`
+}
+
+// KeyFamily returns the family of a key, the part before its first colon
+// once any namespace and schema version are skipped, so both
+// "candles:AAPL:D:1:2" and "equity:v1:candles:AAPL:D:1:2" are in the
+// "candles" family
+func KeyFamily(key string) string {
+	parts := strings.SplitN(key, ":", 4)
+	switch {
+	case len(parts) >= 3 && isSchemaVersion(parts[1]):
+		return parts[2]
+	case len(parts) >= 2 && isSchemaVersion(parts[0]):
+		return parts[1]
+	default:
+		return parts[0]
+	}
+}
+
+// isSchemaVersion reports whether a key segment looks like "v1"
+func isSchemaVersion(segment string) bool {
+	if len(segment) < 2 || segment[0] != 'v' {
+		return false
+	}
+	for _, r := range segment[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hitCounter counts lookups per key family for a Cache implementation
+type hitCounter struct {
+	mutex    sync.Mutex
+	families map[string]*HitStats
+}
+
+// record counts one lookup of key
+func (h *hitCounter) record(key string, hit bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.families == nil {
+		h.families = make(map[string]*HitStats)
+	}
+	family := KeyFamily(key)
+	stats, ok := h.families[family]
+	if !ok {
+		stats = &HitStats{}
+		h.families[family] = stats
+	}
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+}
+
+// snapshot totals the counts with hit ratios filled in
+func (h *hitCounter) snapshot(backend string) Stats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stats := Stats{
+		Backend:  backend,
+		Families: make(map[string]HitStats, len(h.families)),
+	}
+	for family, counts := range h.families {
+		stats.Families[family] = hitStats(counts.Hits, counts.Misses)
+		stats.Hits += counts.Hits
+		stats.Misses += counts.Misses
+	}
+	stats.HitStats = hitStats(stats.Hits, stats.Misses)
+
+	return stats
+}
+
+func hitStats(hits, misses int64) HitStats {
+	stats := HitStats{Hits: hits, Misses: misses}
+	if total := hits + misses; total > 0 {
+		stats.HitRatio = float64(hits) / float64(total)
+	}
+	return stats
 }
 
 // Common errors
@@ -32,7 +145,9 @@ func (e *CacheError) Error() string {
 	return e.Message
 }
 
-// Cache TTL constants
+// Cache TTL constants. Market data is served from cache until its TTL and
+// refreshed in the background until its hard TTL; after that callers wait
+// for a fresh fetch.
 const (
 	QuoteTTL     = 1 * time.Minute
 	CandleTTL    = 5 * time.Minute
@@ -40,4 +155,14 @@ const (
 	NewsTTL      = 15 * time.Minute
 	OrderBookTTL = 5 * time.Second
 	SearchTTL    = 1 * time.Hour
+
+	QuoteHardTTL   = 5 * time.Minute
+	CandleHardTTL  = 30 * time.Minute
+	ProfileHardTTL = 7 * 24 * time.Hour
+	NewsHardTTL    = 1 * time.Hour
+	SearchHardTTL  = 24 * time.Hour
+
+	// StaleTTL keeps values around past their hard TTL to serve, marked
+	// stale, while upstream is failing
+	StaleTTL = 24 * time.Hour
 )
\ No newline at end of file
@@ -1,62 +1,163 @@
 package cache
 
 import (
+	"container/heap"
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
-// MemoryCache implements Cache interface using in-memory storage
+// EvictionPolicy picks which entry a full MemoryCache drops
+type EvictionPolicy string
+
+const (
+	// EvictLRU drops the least recently used entry
+	EvictLRU EvictionPolicy = "lru"
+
+	// EvictLFU drops the least frequently used entry, oldest first on ties
+	EvictLFU EvictionPolicy = "lfu"
+)
+
+// MemoryCacheOptions bounds a MemoryCache. Zero limits mean unbounded.
+type MemoryCacheOptions struct {
+	MaxEntries int
+	MaxBytes   int64
+	Policy     EvictionPolicy
+}
+
+// MemoryStats is a snapshot of a MemoryCache's size and evictions
+type MemoryStats struct {
+	Entries     int            `json:"entries"`
+	Bytes       int64          `json:"bytes"`
+	MaxEntries  int            `json:"maxEntries,omitempty"`
+	MaxBytes    int64          `json:"maxBytes,omitempty"`
+	Policy      EvictionPolicy `json:"policy"`
+	Evictions   int64          `json:"evictions"`
+	Expirations int64          `json:"expirations"`
+}
+
+// MemoryCache implements Cache interface using in-memory storage. Entries
+// are kept in two heaps: one ordered by eviction priority, used when the
+// cache is over its limits, and one by expiration, so expired entries are
+// removed without scanning the whole cache.
 type MemoryCache struct {
-	data  map[string]*cacheItem
-	mutex sync.RWMutex
+	data     map[string]*cacheItem
+	byUsage  *itemHeap
+	byExpiry *itemHeap
+	options  MemoryCacheOptions
+	bytes    int64
+	clock    uint64
+	mutex    sync.Mutex
+
+	evictions   int64
+	expirations int64
+
+	lookups hitCounter
 }
 
 type cacheItem struct {
+	key        string
 	value      []byte
 	expiration time.Time
+
+	hits       uint64
+	lastAccess uint64
+
+	usageIndex  int
+	expiryIndex int
+}
+
+// size is what an entry counts against MaxBytes
+func (item *cacheItem) size() int64 {
+	return int64(len(item.key) + len(item.value))
 }
 
 // NewMemoryCache creates a new in-memory cache
-func NewMemoryCache() *MemoryCache {
+func NewMemoryCache(options MemoryCacheOptions) *MemoryCache {
+	if options.Policy == "" {
+		options.Policy = EvictLRU
+	}
+
 	cache := &MemoryCache{
-		data: make(map[string]*cacheItem),
+		data:     make(map[string]*cacheItem),
+		options:  options,
+		byUsage:  newUsageHeap(options.Policy),
+		byExpiry: newExpiryHeap(),
 	}
-	
+
 	// Start cleanup goroutine
 	go cache.cleanup()
-	
+
 	return cache
 }
 
+// ParseEvictionPolicy validates an eviction policy name
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch policy := EvictionPolicy(name); policy {
+	case EvictLRU, EvictLFU:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy %q", name)
+	}
+}
+
 // Get retrieves a value from memory cache
 func (m *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	item, exists := m.data[key]
 	if !exists {
+		m.lookups.record(key, false)
 		return nil, ErrCacheMiss
 	}
 
 	// Check if expired
 	if time.Now().After(item.expiration) {
+		m.remove(item)
+		m.expirations++
+		m.lookups.record(key, false)
 		return nil, ErrCacheMiss
 	}
 
+	m.touch(item)
+	m.lookups.record(key, true)
 	return item.value, nil
 }
 
-// Set stores a value in memory cache with expiration
+// Set stores a value in memory cache with expiration, evicting other
+// entries if it puts the cache over its limits
 func (m *MemoryCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.data[key] = &cacheItem{
+	item := &cacheItem{
+		key:        key,
 		value:      value,
 		expiration: time.Now().Add(expiration),
 	}
+	if m.options.MaxBytes > 0 && item.size() > m.options.MaxBytes {
+		return &CacheError{Message: "value exceeds memory cache size limit"}
+	}
 
+	if existing, exists := m.data[key]; exists {
+		// Keep the entry's usage history across updates
+		item.hits = existing.hits
+		m.remove(existing)
+	}
+
+	m.clock++
+	item.hits++
+	item.lastAccess = m.clock
+
+	m.data[key] = item
+	m.bytes += item.size()
+	heap.Push(m.byUsage, item)
+	heap.Push(m.byExpiry, item)
+
+	m.evict()
 	return nil
 }
 
@@ -65,14 +166,16 @@ func (m *MemoryCache) Delete(ctx context.Context, key string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	delete(m.data, key)
+	if item, exists := m.data[key]; exists {
+		m.remove(item)
+	}
 	return nil
 }
 
 // Exists checks if a key exists in memory cache
 func (m *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	item, exists := m.data[key]
 	if !exists {
@@ -87,19 +190,188 @@ func (m *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
 	return true, nil
 }
 
+// Keys lists up to limit live keys starting with prefix
+func (m *MemoryCache) Keys(ctx context.Context, prefix string, limit int) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	keys := make([]string, 0)
+	for key, item := range m.data {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if strings.HasPrefix(key, prefix) && !now.After(item.expiration) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// DeletePrefix removes every key starting with prefix
+func (m *MemoryCache) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deleted := 0
+	for key, item := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(item)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Stats returns hits and misses per key family
+func (m *MemoryCache) Stats() Stats {
+	return m.lookups.snapshot("memory")
+}
+
+// Usage returns the cache's size and eviction counters
+func (m *MemoryCache) Usage() MemoryStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return MemoryStats{
+		Entries:     len(m.data),
+		Bytes:       m.bytes,
+		MaxEntries:  m.options.MaxEntries,
+		MaxBytes:    m.options.MaxBytes,
+		Policy:      m.options.Policy,
+		Evictions:   m.evictions,
+		Expirations: m.expirations,
+	}
+}
+
+// clear removes every item
+func (m *MemoryCache) clear() {
+	m.mutex.Lock()
+	m.data = make(map[string]*cacheItem)
+	m.byUsage = newUsageHeap(m.options.Policy)
+	m.byExpiry = newExpiryHeap()
+	m.bytes = 0
+	m.mutex.Unlock()
+}
+
+// touch records a use of a cached item. Callers hold the write lock.
+func (m *MemoryCache) touch(item *cacheItem) {
+	m.clock++
+	item.hits++
+	item.lastAccess = m.clock
+	heap.Fix(m.byUsage, item.usageIndex)
+}
+
+// remove drops item from the map and both heaps. Callers hold the write lock.
+func (m *MemoryCache) remove(item *cacheItem) {
+	delete(m.data, item.key)
+	heap.Remove(m.byUsage, item.usageIndex)
+	heap.Remove(m.byExpiry, item.expiryIndex)
+	m.bytes -= item.size()
+}
+
+// evict drops entries by policy until the cache is within its limits.
+// Expired entries go first since they cost nothing to lose.
+func (m *MemoryCache) evict() {
+	if !m.overLimit() {
+		return
+	}
+
+	m.expire(time.Now())
+	for m.overLimit() && m.byUsage.Len() > 0 {
+		m.remove(m.byUsage.items[0])
+		m.evictions++
+	}
+}
+
+func (m *MemoryCache) overLimit() bool {
+	return (m.options.MaxEntries > 0 && len(m.data) > m.options.MaxEntries) ||
+		(m.options.MaxBytes > 0 && m.bytes > m.options.MaxBytes)
+}
+
+// expire removes entries that expired before now, soonest first, stopping
+// at the first live one. Callers hold the write lock.
+func (m *MemoryCache) expire(now time.Time) {
+	for m.byExpiry.Len() > 0 {
+		item := m.byExpiry.items[0]
+		if !now.After(item.expiration) {
+			return
+		}
+		m.remove(item)
+		m.expirations++
+	}
+}
+
 // cleanup removes expired items from the cache
 func (m *MemoryCache) cleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		m.mutex.Lock()
-		now := time.Now()
-		for key, item := range m.data {
-			if now.After(item.expiration) {
-				delete(m.data, key)
+		m.expire(time.Now())
+		m.mutex.Unlock()
+	}
+}
+
+// itemHeap is a heap of cache items that keeps each item's position up to
+// date so items can be fixed or removed in place
+type itemHeap struct {
+	items []*cacheItem
+	less  func(a, b *cacheItem) bool
+	index func(item *cacheItem) *int
+}
+
+// newUsageHeap orders items by which should be evicted first
+func newUsageHeap(policy EvictionPolicy) *itemHeap {
+	less := func(a, b *cacheItem) bool {
+		return a.lastAccess < b.lastAccess
+	}
+	if policy == EvictLFU {
+		less = func(a, b *cacheItem) bool {
+			if a.hits != b.hits {
+				return a.hits < b.hits
 			}
+			return a.lastAccess < b.lastAccess
 		}
-		m.mutex.Unlock()
 	}
-}
\ No newline at end of file
+
+	return &itemHeap{
+		less:  less,
+		index: func(item *cacheItem) *int { return &item.usageIndex },
+	}
+}
+
+// newExpiryHeap orders items by expiration, soonest first
+func newExpiryHeap() *itemHeap {
+	return &itemHeap{
+		less:  func(a, b *cacheItem) bool { return a.expiration.Before(b.expiration) },
+		index: func(item *cacheItem) *int { return &item.expiryIndex },
+	}
+}
+
+func (h *itemHeap) Len() int           { return len(h.items) }
+func (h *itemHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h *itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	*h.index(h.items[i]) = i
+	*h.index(h.items[j]) = j
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	item := x.(*cacheItem)
+	*h.index(item) = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *itemHeap) Pop() interface{} {
+	last := len(h.items) - 1
+	item := h.items[last]
+	h.items[last] = nil
+	h.items = h.items[:last]
+	*h.index(item) = -1
+	return item
+}
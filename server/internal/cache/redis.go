@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"equity-server/internal/config"
@@ -11,9 +12,13 @@ import (
 
 // RedisCache implements Cache interface using Redis
 type RedisCache struct {
-	client *redis.Client
+	client  *redis.Client
+	lookups hitCounter
 }
 
+// Keys are scanned and deleted in batches of this size
+const redisScanBatch = 500
+
 // NewRedisCache creates a new Redis cache client
 func NewRedisCache(cfg config.RedisConfig) (*RedisCache, error) {
 	client := redis.NewClient(&redis.Options{
@@ -39,13 +44,35 @@ func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
 	val, err := r.client.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
+			r.lookups.record(key, false)
 			return nil, ErrCacheMiss
 		}
 		return nil, &CacheError{Message: "redis get failed", Err: err}
 	}
+	r.lookups.record(key, true)
 	return []byte(val), nil
 }
 
+// getWithTTL retrieves a value and its remaining time to live in one round
+// trip
+func (r *RedisCache) getWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	pipe := r.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if err == redis.Nil {
+			return nil, 0, ErrCacheMiss
+		}
+		return nil, 0, &CacheError{Message: "redis get failed", Err: err}
+	}
+
+	val, err := get.Bytes()
+	if err != nil {
+		return nil, 0, ErrCacheMiss
+	}
+	return val, ttl.Val(), nil
+}
+
 // Set stores a value in Redis with expiration
 func (r *RedisCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
 	err := r.client.SetEX(ctx, key, value, expiration).Err()
@@ -73,7 +100,76 @@ func (r *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
 	return count > 0, nil
 }
 
+// Keys scans for up to limit keys starting with prefix
+func (r *RedisCache) Keys(ctx context.Context, prefix string, limit int) ([]string, error) {
+	keys := make([]string, 0)
+	iter := r.client.Scan(ctx, 0, escapeGlob(prefix)+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return keys, &CacheError{Message: "redis scan failed", Err: err}
+	}
+	return keys, nil
+}
+
+// DeletePrefix scans for keys starting with prefix and unlinks them in
+// batches, so large families don't block Redis
+func (r *RedisCache) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted := 0
+	batch := make([]string, 0, redisScanBatch)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := r.client.Unlink(ctx, batch...).Result()
+		deleted += int(n)
+		batch = batch[:0]
+		return err
+	}
+
+	iter := r.client.Scan(ctx, 0, escapeGlob(prefix)+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == redisScanBatch {
+			if err := flush(); err != nil {
+				return deleted, &CacheError{Message: "redis delete failed", Err: err}
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, &CacheError{Message: "redis scan failed", Err: err}
+	}
+	if err := flush(); err != nil {
+		return deleted, &CacheError{Message: "redis delete failed", Err: err}
+	}
+
+	return deleted, nil
+}
+
+// Client returns the underlying Redis client, for features that coordinate
+// through Redis beyond caching
+func (r *RedisCache) Client() *redis.Client {
+	return r.client
+}
+
+// Stats returns hits and misses per key family
+func (r *RedisCache) Stats() Stats {
+	return r.lookups.snapshot("redis")
+}
+
 // Close closes the Redis connection
 func (r *RedisCache) Close() error {
 	return r.client.Close()
-}
\ No newline at end of file
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as patterns
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes. Files with
+// any other version are ignored rather than half-loaded.
+const snapshotVersion = 1
+
+// snapshotFile is the on-disk layout of a MemoryCache snapshot
+type snapshotFile struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"savedAt"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Hits      uint64    `json:"hits,omitempty"`
+}
+
+// SaveSnapshot writes every live entry to path. The file is written to a
+// temporary name and renamed into place, so a crash mid-write never leaves
+// a truncated snapshot behind.
+func (m *MemoryCache) SaveSnapshot(path string) (int, error) {
+	snapshot := snapshotFile{
+		Version: snapshotVersion,
+		SavedAt: time.Now(),
+	}
+
+	m.mutex.Lock()
+	snapshot.Entries = make([]snapshotEntry, 0, len(m.data))
+	for _, item := range m.data {
+		if snapshot.SavedAt.After(item.expiration) {
+			continue
+		}
+		snapshot.Entries = append(snapshot.Entries, snapshotEntry{
+			Key:       item.key,
+			Value:     item.value,
+			ExpiresAt: item.expiration,
+			Hits:      item.hits,
+		})
+	}
+	m.mutex.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+
+	return len(snapshot.Entries), nil
+}
+
+// LoadSnapshot restores entries saved by SaveSnapshot, dropping any that
+// expired in the meantime, and returns how many were loaded. A missing file
+// loads nothing without error.
+func (m *MemoryCache) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Check the version before trusting the rest of the layout
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("corrupt cache snapshot: %w", err)
+	}
+	if header.Version != snapshotVersion {
+		return 0, fmt.Errorf("cache snapshot version %d not supported (want %d)", header.Version, snapshotVersion)
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("corrupt cache snapshot: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	loaded := 0
+	for _, entry := range snapshot.Entries {
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		m.restore(entry)
+		loaded++
+	}
+	m.evict()
+
+	return loaded, nil
+}
+
+// restore inserts a snapshot entry with its original expiration and usage.
+// Callers hold the write lock.
+func (m *MemoryCache) restore(entry snapshotEntry) {
+	if existing, exists := m.data[entry.Key]; exists {
+		m.remove(existing)
+	}
+
+	m.clock++
+	item := &cacheItem{
+		key:        entry.Key,
+		value:      entry.Value,
+		expiration: entry.ExpiresAt,
+		hits:       entry.Hits,
+		lastAccess: m.clock,
+	}
+
+	m.data[item.key] = item
+	m.bytes += item.size()
+	heap.Push(m.byUsage, item)
+	heap.Push(m.byExpiry, item)
+}
+
+// Snapshotter saves a MemoryCache to disk on an interval and once more on
+// shutdown
+type Snapshotter struct {
+	cache    *MemoryCache
+	path     string
+	interval time.Duration
+
+	done     chan struct{}
+	stopped  chan struct{}
+	shutdown sync.Once
+}
+
+// NewSnapshotter creates a snapshotter for cache. An interval of zero only
+// saves on shutdown.
+func NewSnapshotter(cache *MemoryCache, path string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{
+		cache:    cache,
+		path:     path,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run saves snapshots on the interval until Shutdown is called
+func (s *Snapshotter) Run() {
+	defer close(s.stopped)
+
+	if s.interval <= 0 {
+		<-s.done
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops the interval and writes a final snapshot
+func (s *Snapshotter) Shutdown() {
+	s.shutdown.Do(func() {
+		close(s.done)
+		<-s.stopped
+		s.save()
+	})
+}
+
+func (s *Snapshotter) save() {
+	start := time.Now()
+	count, err := s.cache.SaveSnapshot(s.path)
+	if err != nil {
+		log.Printf("Cache snapshot to %s failed: %v", s.path, err)
+		return
+	}
+	log.Printf("Saved %d cache entries to %s in %v", count, s.path, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is part of every key a Store builds. Bump it whenever a
+// cached model changes shape, so instances on different versions during a
+// rolling deploy read and write separate keys instead of each other's.
+const SchemaVersion = 1
+
+// Store reads and writes Go values through a Cache. Keys are prefixed with
+// a namespace and SchemaVersion and values are encoded by a Serializer.
+// Payloads that fail to decode count as misses and are tallied per key
+// family.
+type Store struct {
+	cache      Cache
+	prefix     string
+	serializer *Serializer
+
+	mutex       sync.Mutex
+	badPayloads map[string]int64
+}
+
+// NewStore creates a store writing keys like "<namespace>:v1:quote:AAPL"
+func NewStore(cache Cache, namespace string, serializer *Serializer) *Store {
+	prefix := fmt.Sprintf("v%d:", SchemaVersion)
+	if namespace != "" {
+		prefix = namespace + ":" + prefix
+	}
+
+	return &Store{
+		cache:       cache,
+		prefix:      prefix,
+		serializer:  serializer,
+		badPayloads: make(map[string]int64),
+	}
+}
+
+// Key builds the key for a family and its parts, such as
+// Key("candles", "AAPL", "D", from, to)
+func (s *Store) Key(family string, parts ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(family)
+	for _, part := range parts {
+		fmt.Fprintf(&b, ":%v", part)
+	}
+	return b.String()
+}
+
+// Get reads key into out. A missing key or a payload that fails to decode
+// returns ErrCacheMiss.
+func (s *Store) Get(ctx context.Context, key string, out interface{}) error {
+	data, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return s.Decode(key, data, out)
+}
+
+// Set encodes value and writes it to key
+func (s *Store) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := s.Encode(value)
+	if err != nil {
+		return err
+	}
+	return s.SetEncoded(ctx, key, data, expiration)
+}
+
+// SetEncoded writes a payload from Encode to key
+func (s *Store) SetEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	return s.cache.Set(ctx, key, data, expiration)
+}
+
+// Encode encodes value the way Set would
+func (s *Store) Encode(value interface{}) ([]byte, error) {
+	return s.serializer.Encode(value)
+}
+
+// Decode decodes a payload read from key into out, counting it against
+// key's family and returning ErrCacheMiss if it is malformed
+func (s *Store) Decode(key string, data []byte, out interface{}) error {
+	if err := s.serializer.Decode(data, out); err != nil {
+		s.mutex.Lock()
+		s.badPayloads[KeyFamily(key)]++
+		s.mutex.Unlock()
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Stats returns the underlying cache's stats, with malformed payloads moved
+// from hits to misses
+func (s *Store) Stats() Stats {
+	stats := s.cache.Stats()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var total int64
+	for family, bad := range s.badPayloads {
+		stats.Families[family] = withBadPayloads(stats.Families[family], bad)
+		total += bad
+	}
+	stats.HitStats = withBadPayloads(stats.HitStats, total)
+
+	return stats
+}
+
+func withBadPayloads(stats HitStats, bad int64) HitStats {
+	adjusted := hitStats(stats.Hits-bad, stats.Misses+bad)
+	adjusted.BadPayloads = bad
+	return adjusted
+}
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TieredCache keeps a small in-process L1 cache in front of Redis. L1
+// entries live for at most the L1 TTL and never outlive their Redis copy.
+// Writes and deletes are broadcast over Redis pub/sub so other instances
+// drop their L1 copy of the key.
+type TieredCache struct {
+	l1         *MemoryCache
+	l2         *RedisCache
+	l1TTL      time.Duration
+	channel    string
+	instanceID string
+
+	pubsub *redis.PubSub
+	done   chan struct{}
+
+	lookups       hitCounter
+	l1Hits        int64
+	l1Misses      int64
+	l2Hits        int64
+	l2Misses      int64
+	invalidations int64
+}
+
+// TieredStats is a snapshot of a TieredCache's hit ratios per tier, plus
+// how many invalidations it has received from other instances
+type TieredStats struct {
+	L1            HitStats    `json:"l1"`
+	L2            HitStats    `json:"l2"`
+	L1Usage       MemoryStats `json:"l1Usage"`
+	Invalidations int64       `json:"invalidations"`
+}
+
+// NewTieredCache creates a tiered cache with l1 in front of redisCache and
+// starts listening for invalidations on channel
+func NewTieredCache(l1 *MemoryCache, redisCache *RedisCache, l1TTL time.Duration, channel string) *TieredCache {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	t := &TieredCache{
+		l1:         l1,
+		l2:         redisCache,
+		l1TTL:      l1TTL,
+		channel:    channel,
+		instanceID: hex.EncodeToString(id),
+		pubsub:     redisCache.client.Subscribe(context.Background(), channel),
+		done:       make(chan struct{}),
+	}
+
+	go t.listen()
+
+	return t
+}
+
+// Get reads from L1, falling back to Redis and filling L1 on a hit
+func (t *TieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := t.l1.Get(ctx, key); err == nil {
+		atomic.AddInt64(&t.l1Hits, 1)
+		t.lookups.record(key, true)
+		return value, nil
+	}
+	atomic.AddInt64(&t.l1Misses, 1)
+
+	value, ttl, err := t.l2.getWithTTL(ctx, key)
+	if err != nil {
+		if err == ErrCacheMiss {
+			atomic.AddInt64(&t.l2Misses, 1)
+			t.lookups.record(key, false)
+		}
+		return nil, err
+	}
+	atomic.AddInt64(&t.l2Hits, 1)
+	t.lookups.record(key, true)
+
+	t.l1.Set(ctx, key, value, t.l1Expiration(ttl))
+	return value, nil
+}
+
+// Set writes through to Redis and L1 and tells other instances to drop
+// their copy
+func (t *TieredCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if err := t.l2.Set(ctx, key, value, expiration); err != nil {
+		return err
+	}
+
+	t.l1.Set(ctx, key, value, t.l1Expiration(expiration))
+	t.publish(ctx, invalidateKey, key)
+	return nil
+}
+
+// Delete removes a key from both tiers on every instance
+func (t *TieredCache) Delete(ctx context.Context, key string) error {
+	t.l1.Delete(ctx, key)
+	if err := t.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	t.publish(ctx, invalidateKey, key)
+	return nil
+}
+
+// Exists checks L1, then Redis
+func (t *TieredCache) Exists(ctx context.Context, key string) (bool, error) {
+	if exists, _ := t.l1.Exists(ctx, key); exists {
+		return true, nil
+	}
+	return t.l2.Exists(ctx, key)
+}
+
+// Keys lists keys from Redis, which holds everything L1 does
+func (t *TieredCache) Keys(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return t.l2.Keys(ctx, prefix, limit)
+}
+
+// DeletePrefix removes matching keys from both tiers on every instance and
+// returns how many Redis held
+func (t *TieredCache) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	t.l1.DeletePrefix(ctx, prefix)
+	deleted, err := t.l2.DeletePrefix(ctx, prefix)
+	if err != nil {
+		return deleted, err
+	}
+
+	t.publish(ctx, invalidatePrefix, prefix)
+	return deleted, nil
+}
+
+// Stats returns hits and misses across both tiers, per key family
+func (t *TieredCache) Stats() Stats {
+	return t.lookups.snapshot("tiered")
+}
+
+// Tiers returns hit ratios per tier
+func (t *TieredCache) Tiers() TieredStats {
+	return TieredStats{
+		L1:            hitStats(atomic.LoadInt64(&t.l1Hits), atomic.LoadInt64(&t.l1Misses)),
+		L2:            hitStats(atomic.LoadInt64(&t.l2Hits), atomic.LoadInt64(&t.l2Misses)),
+		L1Usage:       t.l1.Usage(),
+		Invalidations: atomic.LoadInt64(&t.invalidations),
+	}
+}
+
+// Close stops listening for invalidations and closes the Redis connection
+func (t *TieredCache) Close() error {
+	close(t.done)
+	t.pubsub.Close()
+	return t.l2.Close()
+}
+
+// l1Expiration caps an L1 entry at the L1 TTL and its remaining Redis TTL
+func (t *TieredCache) l1Expiration(remaining time.Duration) time.Duration {
+	if remaining > 0 && remaining < t.l1TTL {
+		return remaining
+	}
+	return t.l1TTL
+}
+
+// Invalidation message kinds
+const (
+	invalidateKey    = "key"
+	invalidatePrefix = "prefix"
+)
+
+// publish broadcasts an invalidation for a key or prefix, tagged with this
+// instance so it can ignore its own messages
+func (t *TieredCache) publish(ctx context.Context, kind, target string) {
+	payload := strings.Join([]string{t.instanceID, kind, target}, "|")
+	if err := t.l2.client.Publish(ctx, t.channel, payload).Err(); err != nil {
+		log.Printf("Cache invalidation publish failed for %s: %v", target, err)
+	}
+}
+
+// listen drops L1 entries invalidated by other instances. Messages sent
+// while the subscription was down are lost, so L1 is cleared whenever it
+// resubscribes.
+func (t *TieredCache) listen() {
+	subscribed := false
+
+	for {
+		msg, err := t.pubsub.Receive(context.Background())
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind != "subscribe" {
+				continue
+			}
+			if subscribed {
+				t.l1.clear()
+			}
+			subscribed = true
+		case *redis.Message:
+			parts := strings.SplitN(m.Payload, "|", 3)
+			if len(parts) != 3 || parts[0] == t.instanceID {
+				continue
+			}
+			switch parts[1] {
+			case invalidateKey:
+				t.l1.Delete(context.Background(), parts[2])
+			case invalidatePrefix:
+				t.l1.DeletePrefix(context.Background(), parts[2])
+			default:
+				continue
+			}
+			atomic.AddInt64(&t.invalidations, 1)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package clients
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen fails every call fast until the open timeout passes
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen lets a few probe calls through to test the upstream
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerSettings configures a CircuitBreaker
+type BreakerSettings struct {
+	// Consecutive failures that open the circuit
+	FailureThreshold int
+
+	// How long the circuit stays open before probing
+	OpenTimeout time.Duration
+
+	// Probe calls allowed while half-open; this many successes close the
+	// circuit again, and any failure reopens it
+	HalfOpenProbes int
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	Trips               int64        `json:"trips"`
+	Rejected            int64        `json:"rejected"`
+	OpenedAt            time.Time    `json:"openedAt,omitempty"`
+	RetryAt             time.Time    `json:"retryAt,omitempty"`
+}
+
+// CircuitBreaker stops calls to an upstream that keeps failing, so callers
+// fail fast instead of queueing behind timeouts. A nil *CircuitBreaker lets
+// everything through.
+type CircuitBreaker struct {
+	settings BreakerSettings
+
+	mutex          sync.Mutex
+	state          BreakerState
+	failures       int
+	probesInFlight int
+	probeSuccesses int
+	openedAt       time.Time
+	trips          int64
+	rejected       int64
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+
+	return &CircuitBreaker{
+		settings: settings,
+		state:    BreakerClosed,
+	}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by exactly one Done.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.settings.OpenTimeout)
+		if wait := time.Until(retryAt); wait > 0 {
+			b.rejected++
+			return &UpstreamError{Kind: KindCircuitOpen, RetryAfter: wait, Message: "circuit breaker open"}
+		}
+		b.state = BreakerHalfOpen
+		b.probesInFlight = 0
+		b.probeSuccesses = 0
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probesInFlight >= b.settings.HalfOpenProbes {
+			b.rejected++
+			return &UpstreamError{Kind: KindCircuitOpen, Message: "circuit breaker half-open, probe in progress"}
+		}
+		b.probesInFlight++
+	}
+
+	return nil
+}
+
+// Done records the outcome of an allowed call. Only upstream outages and
+// bad payloads count against the circuit; rate limiting and cancelled calls
+// say nothing about upstream health.
+func (b *CircuitBreaker) Done(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerHalfOpen && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound):
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.probeSuccesses++
+			if b.probeSuccesses >= b.settings.HalfOpenProbes {
+				b.state = BreakerClosed
+			}
+		}
+	case tripsBreaker(err):
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.settings.FailureThreshold {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+			b.trips++
+		}
+	}
+}
+
+// Status returns a snapshot of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		Rejected:            b.rejected,
+	}
+	if b.state != BreakerClosed {
+		status.OpenedAt = b.openedAt
+	}
+	if b.state == BreakerOpen {
+		status.RetryAt = b.openedAt.Add(b.settings.OpenTimeout)
+	}
+
+	return status
+}
+
+// tripsBreaker reports whether an error counts as an upstream failure
+func tripsBreaker(err error) bool {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	switch upstreamErr.Kind {
+	case KindUpstreamDown:
+		return upstreamErr.retryable()
+	case KindBadPayload:
+		return true
+	default:
+		return false
+	}
+}
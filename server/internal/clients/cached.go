@@ -0,0 +1,109 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"equity-server/internal/cache"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheEntry is how FinnhubClient stores a result: the value plus the
+// deadlines that decide whether it is fresh, due for a background refresh,
+// or only good as a stale fallback. To decode one, set Value to a pointer
+// to decode the value into.
+type cacheEntry struct {
+	Value      interface{} `json:"value"`
+	SoftExpiry time.Time   `json:"softExpiry"`
+	HardExpiry time.Time   `json:"hardExpiry"`
+}
+
+// fetchFunc fetches a value from upstream for the cache
+type fetchFunc func(ctx context.Context) (interface{}, error)
+
+// cached serves key from the cache, fetching it with fn when needed, and
+// decodes the result into out:
+//   - before the soft TTL the cached value is returned as is
+//   - between the soft and hard TTL it is returned right away while a
+//     background fetch refreshes it
+//   - past the hard TTL, or when nothing is cached, the caller waits for a
+//     fetch; if that fails the old value is served anyway and reported stale
+func (c *FinnhubClient) cached(ctx context.Context, key string, soft, hard time.Duration, out interface{}, fn fetchFunc) (stale bool, err error) {
+	entry, found := c.lookup(ctx, key, out)
+
+	now := time.Now()
+	if found && now.Before(entry.HardExpiry) {
+		if !now.Before(entry.SoftExpiry) {
+			c.refresh(ctx, key, soft, hard, fn)
+		}
+		return false, nil
+	}
+
+	select {
+	case res := <-c.refresh(ctx, key, soft, hard, fn):
+		if res.Err == nil {
+			// Drop anything left from the cached value first
+			reflect.ValueOf(out).Elem().SetZero()
+			return false, c.cache.Decode(key, res.Val.([]byte), &cacheEntry{Value: out})
+		}
+		err = res.Err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	// An old value beats an error, unless upstream says it no longer exists.
+	// out still holds it from the lookup.
+	if found && !errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// refresh starts, or joins, the one shared fetch for key and stores its
+// result with new deadlines. The fetch is detached from any one caller's
+// cancellation so callers that stop waiting, and background refreshes
+// nobody waits for, still leave a fresh entry behind. Each caller decodes
+// its own copy of the stored entry.
+func (c *FinnhubClient) refresh(ctx context.Context, key string, soft, hard time.Duration, fn fetchFunc) <-chan singleflight.Result {
+	return c.inflight.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedFetchTimeout)
+		defer cancel()
+
+		value, err := fn(fetchCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.store(fetchCtx, key, value, soft, hard)
+	})
+}
+
+// lookup reads the cache entry for key, decoding its value into out
+func (c *FinnhubClient) lookup(ctx context.Context, key string, out interface{}) (*cacheEntry, bool) {
+	entry := &cacheEntry{Value: out}
+	if err := c.cache.Get(ctx, key, entry); err != nil || entry.Value == nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// store writes a cache entry for key and returns the encoded entry. It
+// outlives its hard TTL by cache.StaleTTL so there is something to fall back
+// on when upstream fails.
+func (c *FinnhubClient) store(ctx context.Context, key string, value interface{}, soft, hard time.Duration) ([]byte, error) {
+	now := time.Now()
+	data, err := c.cache.Encode(cacheEntry{
+		Value:      value,
+		SoftExpiry: now.Add(soft),
+		HardExpiry: now.Add(hard),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.SetEncoded(ctx, key, data, hard+cache.StaleTTL)
+	return data, nil
+}
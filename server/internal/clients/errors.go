@@ -0,0 +1,92 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorKind classifies upstream failures so callers can react to them
+type ErrorKind string
+
+const (
+	KindRateLimited   ErrorKind = "rate_limited"
+	KindNotFound      ErrorKind = "not_found"
+	KindUpstreamDown  ErrorKind = "upstream_down"
+	KindBadPayload    ErrorKind = "bad_payload"
+	KindCircuitOpen   ErrorKind = "circuit_open"
+	KindNotConfigured ErrorKind = "not_configured"
+)
+
+// Sentinel errors for use with errors.Is; any UpstreamError of the same
+// kind matches
+var (
+	ErrRateLimited   = &UpstreamError{Kind: KindRateLimited, Message: "upstream rate limit exceeded"}
+	ErrNotFound      = &UpstreamError{Kind: KindNotFound, Message: "not found upstream"}
+	ErrUpstreamDown  = &UpstreamError{Kind: KindUpstreamDown, Message: "upstream unavailable"}
+	ErrBadPayload    = &UpstreamError{Kind: KindBadPayload, Message: "malformed upstream payload"}
+	ErrCircuitOpen   = &UpstreamError{Kind: KindCircuitOpen, Message: "circuit breaker open"}
+	ErrNotConfigured = &UpstreamError{Kind: KindNotConfigured, Message: "upstream not configured"}
+)
+
+// UpstreamError is a classified failure from a market data backend
+type UpstreamError struct {
+	Kind       ErrorKind
+	Status     int           // HTTP status from upstream, if any
+	RetryAfter time.Duration // upstream's Retry-After hint, if any
+	Message    string
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	msg := e.Message
+	if e.Status != 0 {
+		msg = fmt.Sprintf("%s (status %d)", msg, e.Status)
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any UpstreamError of the same kind
+func (e *UpstreamError) Is(target error) bool {
+	t, ok := target.(*UpstreamError)
+	return ok && t.Kind == e.Kind
+}
+
+// retryable reports whether another attempt could succeed
+func (e *UpstreamError) retryable() bool {
+	switch e.Kind {
+	case KindRateLimited:
+		return true
+	case KindUpstreamDown:
+		// Auth and other client errors won't fix themselves
+		return e.Status == 0 || e.Status >= 500
+	default:
+		return false
+	}
+}
+
+// ErrorKindOf returns the kind of an upstream error, or "" for anything else
+func ErrorKindOf(err error) ErrorKind {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind
+	}
+	return ""
+}
+
+// RetryAfterOf returns the Retry-After hint carried by an error, if any
+func RetryAfterOf(err error) time.Duration {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,277 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+const (
+	// Weight of the newest sample in the error rate and latency averages
+	healthSmoothing = 0.2
+
+	// A backend is benched once its error rate passes this threshold...
+	unhealthyErrorRate = 0.5
+
+	// ...or after this many failures in a row
+	unhealthyConsecutiveFailures = 3
+)
+
+// ProviderBackend is a named MarketDataProvider inside a failover chain
+type ProviderBackend struct {
+	Name     string
+	Provider MarketDataProvider
+}
+
+// BackendHealth is a snapshot of one backend's health
+type BackendHealth struct {
+	Name          string         `json:"name"`
+	Healthy       bool           `json:"healthy"`
+	ErrorRate     float64        `json:"errorRate"`
+	AvgLatencyMs  float64        `json:"avgLatencyMs"`
+	Requests      int64          `json:"requests"`
+	Failures      int64          `json:"failures"`
+	LastError     string         `json:"lastError,omitempty"`
+	CooldownUntil time.Time      `json:"cooldownUntil,omitempty"`
+	Circuit       *BreakerStatus `json:"circuit,omitempty"`
+}
+
+// FailoverProvider tries its backends in priority order, skipping any that
+// have recently been failing, and stamps each result with the backend that
+// produced it
+type FailoverProvider struct {
+	backends []*failoverBackend
+	cooldown time.Duration
+}
+
+// failoverBackend tracks health for one backend
+type failoverBackend struct {
+	ProviderBackend
+
+	mutex               sync.Mutex
+	requests            int64
+	failures            int64
+	consecutiveFailures int
+	errorRate           float64
+	avgLatency          time.Duration
+	lastError           string
+	unhealthyUntil      time.Time
+}
+
+// NewFailoverProvider creates a failover chain over the given backends,
+// highest priority first
+func NewFailoverProvider(backends []ProviderBackend, cooldown time.Duration) *FailoverProvider {
+	chain := make([]*failoverBackend, len(backends))
+	for i, b := range backends {
+		chain[i] = &failoverBackend{ProviderBackend: b}
+	}
+
+	return &FailoverProvider{
+		backends: chain,
+		cooldown: cooldown,
+	}
+}
+
+// GetQuote fetches a quote from the first healthy backend
+func (f *FailoverProvider) GetQuote(ctx context.Context, symbol string) (*models.Quote, error) {
+	var quote *models.Quote
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		quote, err = p.GetQuote(ctx, symbol)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	quote.Provider = name
+	return quote, nil
+}
+
+// GetCandles fetches candles from the first healthy backend
+func (f *FailoverProvider) GetCandles(ctx context.Context, symbol, resolution string, from, to int64) (*models.CandleData, error) {
+	var candles *models.CandleData
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		candles, err = p.GetCandles(ctx, symbol, resolution, from, to)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	candles.Provider = name
+	return candles, nil
+}
+
+// GetProfile fetches a company profile from the first healthy backend
+func (f *FailoverProvider) GetProfile(ctx context.Context, symbol string) (*models.CompanyProfile, error) {
+	var profile *models.CompanyProfile
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		profile, err = p.GetProfile(ctx, symbol)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile.Provider = name
+	return profile, nil
+}
+
+// GetNews fetches company news from the first healthy backend
+func (f *FailoverProvider) GetNews(ctx context.Context, symbol, from, to string) ([]models.NewsItem, error) {
+	var news []models.NewsItem
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		news, err = p.GetNews(ctx, symbol, from, to)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range news {
+		news[i].Provider = name
+	}
+	return news, nil
+}
+
+// GetOrderBook fetches an order book from the first healthy backend
+func (f *FailoverProvider) GetOrderBook(ctx context.Context, symbol string) (*models.OrderBook, error) {
+	var orderBook *models.OrderBook
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		orderBook, err = p.GetOrderBook(ctx, symbol)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	orderBook.Provider = name
+	return orderBook, nil
+}
+
+// SearchSymbols searches symbols on the first healthy backend
+func (f *FailoverProvider) SearchSymbols(ctx context.Context, query string) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	name, err := f.try(ctx, func(p MarketDataProvider) (err error) {
+		results, err = p.SearchSymbols(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Provider = name
+	}
+	return results, nil
+}
+
+// Health returns a snapshot of every backend in priority order
+func (f *FailoverProvider) Health() []BackendHealth {
+	now := time.Now()
+	health := make([]BackendHealth, len(f.backends))
+
+	for i, b := range f.backends {
+		b.mutex.Lock()
+		health[i] = BackendHealth{
+			Name:         b.Name,
+			Healthy:      now.After(b.unhealthyUntil),
+			ErrorRate:    b.errorRate,
+			AvgLatencyMs: float64(b.avgLatency) / float64(time.Millisecond),
+			Requests:     b.requests,
+			Failures:     b.failures,
+			LastError:    b.lastError,
+		}
+		if now.Before(b.unhealthyUntil) {
+			health[i].CooldownUntil = b.unhealthyUntil
+		}
+		b.mutex.Unlock()
+
+		if reporter, ok := b.Provider.(CircuitReporter); ok {
+			status := reporter.CircuitStatus()
+			health[i].Circuit = &status
+		}
+	}
+
+	return health
+}
+
+// try runs op against healthy backends in order and returns the name of the
+// one that succeeded. When every backend is cooling down they are all tried
+// anyway, since a possibly-failing answer beats a certain one.
+func (f *FailoverProvider) try(ctx context.Context, op func(MarketDataProvider) error) (string, error) {
+	candidates := make([]*failoverBackend, 0, len(f.backends))
+	now := time.Now()
+	for _, b := range f.backends {
+		if b.healthy(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = f.backends
+	}
+
+	var lastErr error
+	for _, b := range candidates {
+		start := time.Now()
+		err := op(b.Provider)
+
+		// The caller giving up says nothing about the backend
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		// A backend that says "no such symbol" is working, and asking the
+		// next one would only invent data for it
+		if errors.Is(err, ErrNotFound) {
+			b.record(time.Since(start), nil, f.cooldown)
+			return "", fmt.Errorf("%s: %w", b.Name, err)
+		}
+
+		b.record(time.Since(start), err, f.cooldown)
+		if err == nil {
+			return b.Name, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", b.Name, err)
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no market data providers configured")
+	}
+	return "", lastErr
+}
+
+func (b *failoverBackend) healthy(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return now.After(b.unhealthyUntil)
+}
+
+// record folds one call into the backend's health and benches it when needed
+func (b *failoverBackend) record(latency time.Duration, err error, cooldown time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.requests++
+	if b.requests == 1 {
+		b.avgLatency = latency
+	} else {
+		b.avgLatency = time.Duration(healthSmoothing*float64(latency) + (1-healthSmoothing)*float64(b.avgLatency))
+	}
+
+	sample := 0.0
+	if err != nil {
+		sample = 1
+		b.failures++
+		b.consecutiveFailures++
+		b.lastError = err.Error()
+	} else {
+		b.consecutiveFailures = 0
+	}
+	b.errorRate = healthSmoothing*sample + (1-healthSmoothing)*b.errorRate
+
+	if err != nil && (b.errorRate > unhealthyErrorRate || b.consecutiveFailures >= unhealthyConsecutiveFailures) {
+		b.unhealthyUntil = time.Now().Add(cooldown)
+		b.consecutiveFailures = 0
+		// Start the next probation period from a clean slate
+		b.errorRate = unhealthyErrorRate / 2
+	}
+}
@@ -3,267 +3,258 @@ package clients
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"equity-server/internal/cache"
 	"equity-server/internal/models"
 
-	"golang.org/x/time/rate"
+	"golang.org/x/sync/singleflight"
 )
 
 // FinnhubClient handles communication with Finnhub API
 type FinnhubClient struct {
-	apiKey      string
-	baseURL     string
-	httpClient  *http.Client
-	rateLimiter *rate.Limiter
-	cache       cache.Cache
-	mutex       sync.RWMutex
+	keys       *KeyPool
+	baseURL    string
+	httpClient *http.Client
+	cache      *cache.Store
+	breaker    *CircuitBreaker
+	inflight   singleflight.Group
+	mutex      sync.RWMutex
 }
 
-// NewFinnhubClient creates a new Finnhub API client
-func NewFinnhubClient(apiKey string, cache cache.Cache) *FinnhubClient {
+// DefaultFinnhubBaseURL is the production Finnhub REST endpoint
+const DefaultFinnhubBaseURL = "https://finnhub.io/api/v1"
+
+const (
+	// Attempts per request, including the first
+	maxFetchAttempts = 3
+
+	// Exponential backoff bounds between attempts
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+
+	// Retry-After hints longer than this are returned to the caller rather
+	// than waited out
+	maxRetryAfterWait = 10 * time.Second
+
+	// Upper bound on a fetch shared by coalesced callers or running in the
+	// background, which no longer follows any one caller's deadline
+	sharedFetchTimeout = time.Minute
+)
+
+// NewFinnhubClient creates a new Finnhub API client. An empty baseURL uses
+// DefaultFinnhubBaseURL. A nil transport uses http.DefaultTransport; pass a
+// fixture transport to record or replay traffic. A nil breaker never trips.
+func NewFinnhubClient(keys *KeyPool, baseURL string, cache *cache.Store, transport http.RoundTripper, breaker *CircuitBreaker) *FinnhubClient {
+	if baseURL == "" {
+		baseURL = DefaultFinnhubBaseURL
+	}
+
 	return &FinnhubClient{
-		apiKey:  apiKey,
-		baseURL: "https://finnhub.io/api/v1",
+		keys:    keys,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
-		// Finnhub allows 60 requests per minute for free tier
-		rateLimiter: rate.NewLimiter(rate.Every(time.Minute/60), 10),
-		cache:       cache,
+		cache:   cache,
+		breaker: breaker,
 	}
 }
 
+// CircuitStatus returns the state of the client's circuit breaker
+func (c *FinnhubClient) CircuitStatus() BreakerStatus {
+	return c.breaker.Status()
+}
+
 // GetQuote fetches a stock quote
 func (c *FinnhubClient) GetQuote(ctx context.Context, symbol string) (*models.Quote, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("quote:%s", symbol)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
-		var quote models.Quote
-		if err := json.Unmarshal(cached, &quote); err == nil {
-			return &quote, nil
-		}
-	}
+	cacheKey := c.cache.Key("quote", symbol)
 
-	// Rate limiting
-	if err := c.rateLimiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limit exceeded: %w", err)
-	}
+	var quote models.Quote
+	stale, err := c.cached(ctx, cacheKey, cache.QuoteTTL, cache.QuoteHardTTL, &quote, func(ctx context.Context) (interface{}, error) {
+		var quoteResp map[string]interface{}
+		if err := c.fetch(ctx, "/quote", url.Values{"symbol": {symbol}}, &quoteResp); err != nil {
+			return nil, err
+		}
 
-	// Fetch from API
-	url := fmt.Sprintf("%s/quote?symbol=%s&token=%s", c.baseURL, symbol, c.apiKey)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+		// Finnhub answers unknown symbols with an all-zero quote
+		if getFloat64(quoteResp, "c") == 0 && getFloat64(quoteResp, "pc") == 0 {
+			return nil, &UpstreamError{Kind: KindNotFound, Message: "no quote for " + symbol}
+		}
 
-	resp, err := c.httpClient.Do(req)
+		return &models.Quote{
+			Symbol:        symbol,
+			CurrentPrice:  getFloat64(quoteResp, "c"),
+			Change:        getFloat64(quoteResp, "d"),
+			PercentChange: getFloat64(quoteResp, "dp"),
+			High:          getFloat64(quoteResp, "h"),
+			Low:           getFloat64(quoteResp, "l"),
+			Open:          getFloat64(quoteResp, "o"),
+			PreviousClose: getFloat64(quoteResp, "pc"),
+			Timestamp:     time.Now(),
+		}, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %d", resp.StatusCode)
-	}
-
-	var quoteResp map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&quoteResp); err != nil {
-		return nil, err
-	}
-
-	quote := &models.Quote{
-		Symbol:        symbol,
-		CurrentPrice:  getFloat64(quoteResp, "c"),
-		Change:        getFloat64(quoteResp, "d"),
-		PercentChange: getFloat64(quoteResp, "dp"),
-		High:          getFloat64(quoteResp, "h"),
-		Low:           getFloat64(quoteResp, "l"),
-		Open:          getFloat64(quoteResp, "o"),
-		PreviousClose: getFloat64(quoteResp, "pc"),
-		Timestamp:     time.Now(),
-	}
-
-	// Cache the result
-	if data, err := json.Marshal(quote); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.QuoteTTL)
-	}
 
-	return quote, nil
+	quote.Stale = stale
+	return &quote, nil
 }
 
 // GetCandles fetches candlestick data
 func (c *FinnhubClient) GetCandles(ctx context.Context, symbol, resolution string, from, to int64) (*models.CandleData, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("candles:%s:%s:%d:%d", symbol, resolution, from, to)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
-		var candles models.CandleData
-		if err := json.Unmarshal(cached, &candles); err == nil {
-			return &candles, nil
+	from, to = candleRange(resolution, from, to)
+	cacheKey := c.cache.Key("candles", symbol, resolution, from, to)
+
+	var candles models.CandleData
+	stale, err := c.cached(ctx, cacheKey, cache.CandleTTL, cache.CandleHardTTL, &candles, func(ctx context.Context) (interface{}, error) {
+		var candleResp models.CandleData
+		params := url.Values{
+			"symbol":     {symbol},
+			"resolution": {resolution},
+			"from":       {strconv.FormatInt(from, 10)},
+			"to":         {strconv.FormatInt(to, 10)},
+		}
+		if err := c.fetch(ctx, "/stock/candle", params, &candleResp); err != nil {
+			return nil, err
 		}
-	}
-
-	// Rate limiting
-	if err := c.rateLimiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limit exceeded: %w", err)
-	}
-
-	// Fetch from API
-	url := fmt.Sprintf("%s/stock/candle?symbol=%s&resolution=%s&from=%d&to=%d&token=%s",
-		c.baseURL, symbol, resolution, from, to, c.apiKey)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
 
-	resp, err := c.httpClient.Do(req)
+		candleResp.Symbol = symbol
+		return candleResp, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var candleResp models.CandleData
-	if err := json.NewDecoder(resp.Body).Decode(&candleResp); err != nil {
-		return nil, err
-	}
+	candles.Stale = stale
+	return &candles, nil
+}
 
-	candleResp.Symbol = symbol
+// DefaultCandleDays is the span of candles served when a request names no
+// start, and what the cache warmer prefetches so it fills the same entry
+const DefaultCandleDays = 30
 
-	// Cache the result
-	if data, err := json.Marshal(candleResp); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.CandleTTL)
+// candleRange widens a candle request to whole bars of its resolution,
+// whole UTC days for daily and longer ones, so requests for the same span
+// made moments apart, such as the cache warmer's and a client's, share a
+// cache entry
+func candleRange(resolution string, from, to int64) (int64, int64) {
+	bar := int64(24 * 60 * 60)
+	if minutes, err := strconv.Atoi(resolution); err == nil && minutes > 0 {
+		bar = int64(minutes) * 60
 	}
 
-	return &candleResp, nil
+	from -= from % bar
+	if rest := to % bar; rest != 0 {
+		to += bar - rest
+	}
+	return from, to
 }
 
 // GetProfile fetches company profile
 func (c *FinnhubClient) GetProfile(ctx context.Context, symbol string) (*models.CompanyProfile, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("profile:%s", symbol)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
+	cacheKey := c.cache.Key("profile", symbol)
+
+	var profile models.CompanyProfile
+	stale, err := c.cached(ctx, cacheKey, cache.ProfileTTL, cache.ProfileHardTTL, &profile, func(ctx context.Context) (interface{}, error) {
 		var profile models.CompanyProfile
-		if err := json.Unmarshal(cached, &profile); err == nil {
-			return &profile, nil
+		if err := c.fetch(ctx, "/stock/profile2", url.Values{"symbol": {symbol}}, &profile); err != nil {
+			return nil, err
 		}
-	}
 
-	// Rate limiting
-	if err := c.rateLimiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limit exceeded: %w", err)
-	}
-
-	// Fetch from API
-	url := fmt.Sprintf("%s/stock/profile2?symbol=%s&token=%s", c.baseURL, symbol, c.apiKey)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+		// Finnhub answers unknown symbols with an empty object
+		if profile.Name == "" {
+			return nil, &UpstreamError{Kind: KindNotFound, Message: "no profile for " + symbol}
+		}
 
-	resp, err := c.httpClient.Do(req)
+		profile.Symbol = symbol
+		return profile, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var profile models.CompanyProfile
-	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
-		return nil, err
-	}
-
-	profile.Symbol = symbol
-
-	// Cache the result
-	if data, err := json.Marshal(profile); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.ProfileTTL)
-	}
 
+	profile.Stale = stale
 	return &profile, nil
 }
 
 // GetNews fetches company news
 func (c *FinnhubClient) GetNews(ctx context.Context, symbol, from, to string) ([]models.NewsItem, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("news:%s:%s:%s", symbol, from, to)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
-		var news []models.NewsItem
-		if err := json.Unmarshal(cached, &news); err == nil {
-			return news, nil
+	cacheKey := c.cache.Key("news", symbol, from, to)
+
+	var news []models.NewsItem
+	stale, err := c.cached(ctx, cacheKey, cache.NewsTTL, cache.NewsHardTTL, &news, func(ctx context.Context) (interface{}, error) {
+		var newsResp []map[string]interface{}
+		params := url.Values{
+			"symbol": {symbol},
+			"from":   {from},
+			"to":     {to},
+		}
+		if err := c.fetch(ctx, "/company-news", params, &newsResp); err != nil {
+			return nil, err
 		}
-	}
-
-	// Rate limiting
-	if err := c.rateLimiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limit exceeded: %w", err)
-	}
-
-	// Fetch from API
-	url := fmt.Sprintf("%s/company-news?symbol=%s&from=%s&to=%s&token=%s",
-		c.baseURL, symbol, from, to, c.apiKey)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		news := make([]models.NewsItem, 0, len(newsResp))
+		for i, item := range newsResp {
+			if i >= 10 { // Limit to 10 items
+				break
+			}
 
-	var newsResp []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&newsResp); err != nil {
-		return nil, err
-	}
+			newsItem := models.NewsItem{
+				Headline: getString(item, "headline"),
+				Summary:  getString(item, "summary"),
+				Source:   getString(item, "source"),
+				URL:      getString(item, "url"),
+				Image:    getString(item, "image"),
+				Symbol:   symbol,
+			}
 
-	news := make([]models.NewsItem, 0, len(newsResp))
-	for i, item := range newsResp {
-		if i >= 10 { // Limit to 10 items
-			break
-		}
+			// Parse datetime
+			if dt := getFloat64(item, "datetime"); dt > 0 {
+				newsItem.DateTime = time.Unix(int64(dt), 0)
+			}
 
-		newsItem := models.NewsItem{
-			ID:       fmt.Sprintf("%s-%d", symbol, i),
-			Headline: getString(item, "headline"),
-			Summary:  getString(item, "summary"),
-			Source:   getString(item, "source"),
-			URL:      getString(item, "url"),
-			Image:    getString(item, "image"),
-			Symbol:   symbol,
-		}
+			// Finnhub's id is stable across polls, unlike the article's
+			// place in the list
+			if id := getFloat64(item, "id"); id > 0 {
+				newsItem.ID = strconv.FormatInt(int64(id), 10)
+			} else {
+				newsItem.ID = articleID(symbol, newsItem.DateTime, newsItem.Headline)
+			}
 
-		// Parse datetime
-		if dt := getFloat64(item, "datetime"); dt > 0 {
-			newsItem.DateTime = time.Unix(int64(dt), 0)
+			news = append(news, newsItem)
 		}
 
-		news = append(news, newsItem)
+		return news, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Cache the result
-	if data, err := json.Marshal(news); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.NewsTTL)
+	for i := range news {
+		news[i].Stale = stale
 	}
-
 	return news, nil
 }
 
 // GetOrderBook fetches order book data (mock implementation)
 func (c *FinnhubClient) GetOrderBook(ctx context.Context, symbol string) (*models.OrderBook, error) {
 	// Check cache first
-	cacheKey := fmt.Sprintf("orderbook:%s", symbol)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
-		var orderBook models.OrderBook
-		if err := json.Unmarshal(cached, &orderBook); err == nil {
-			return &orderBook, nil
-		}
+	cacheKey := c.cache.Key("orderbook", symbol)
+	var cached models.OrderBook
+	if err := c.cache.Get(ctx, cacheKey, &cached); err == nil {
+		return &cached, nil
 	}
 
 	// Generate mock order book data since Finnhub doesn't provide this for free
@@ -274,71 +265,154 @@ func (c *FinnhubClient) GetOrderBook(ctx context.Context, symbol string) (*model
 
 	orderBook := c.generateMockOrderBook(symbol, quote.CurrentPrice)
 
-	// Cache the result
-	if data, err := json.Marshal(orderBook); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.OrderBookTTL)
+	// A book built around a stale quote is stale too, and isn't cached
+	if quote.Stale {
+		orderBook.Stale = true
+		return orderBook, nil
 	}
 
+	// Cache the result
+	c.cache.Set(ctx, cacheKey, orderBook, cache.OrderBookTTL)
+
 	return orderBook, nil
 }
 
 // SearchSymbols searches for stock symbols
 func (c *FinnhubClient) SearchSymbols(ctx context.Context, query string) ([]models.SearchResult, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("search:%s", query)
-	if cached, err := c.cache.Get(ctx, cacheKey); err == nil {
-		var results []models.SearchResult
-		if err := json.Unmarshal(cached, &results); err == nil {
-			return results, nil
+	cacheKey := c.cache.Key("search", query)
+
+	var results []models.SearchResult
+	stale, err := c.cached(ctx, cacheKey, cache.SearchTTL, cache.SearchHardTTL, &results, func(ctx context.Context) (interface{}, error) {
+		var searchResp map[string]interface{}
+		if err := c.fetch(ctx, "/search", url.Values{"q": {query}}, &searchResp); err != nil {
+			return nil, err
+		}
+
+		results := make([]models.SearchResult, 0)
+		if resultList, ok := searchResp["result"].([]interface{}); ok {
+			for i, item := range resultList {
+				if i >= 20 { // Limit to 20 results
+					break
+				}
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					result := models.SearchResult{
+						Symbol:      getString(itemMap, "symbol"),
+						Description: getString(itemMap, "description"),
+						Type:        getString(itemMap, "type"),
+					}
+					results = append(results, result)
+				}
+			}
 		}
+
+		return results, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Rate limiting
-	if err := c.rateLimiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limit exceeded: %w", err)
+	for i := range results {
+		results[i].Stale = stale
 	}
+	return results, nil
+}
+
+// fetch is the request pipeline shared by every endpoint. It GETs path with
+// a pooled key, retries transient failures with jittered exponential backoff
+// (waiting out Retry-After when upstream sends one) and decodes the JSON body
+// into out. Failures come back as *UpstreamError; while the circuit breaker
+// is open they come back immediately as ErrCircuitOpen.
+func (c *FinnhubClient) fetch(ctx context.Context, path string, params url.Values, out interface{}) error {
+	var lastErr *UpstreamError
+
+	for attempt := 0; attempt < maxFetchAttempts; attempt++ {
+		if attempt > 0 {
+			wait := retryBackoff(attempt)
+
+			// A 429 benches its key, so the next attempt can use another one
+			// right away; other Retry-After hints are honored as given
+			if lastErr.Status != http.StatusTooManyRequests && lastErr.RetryAfter > wait {
+				wait = lastErr.RetryAfter
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err := c.fetchOnce(ctx, path, params, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	// Fetch from API
-	url := fmt.Sprintf("%s/search?q=%s&token=%s", c.baseURL, query, c.apiKey)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		var upstreamErr *UpstreamError
+		if !errors.As(err, &upstreamErr) || !upstreamErr.retryable() || upstreamErr.RetryAfter > maxRetryAfterWait {
+			return err
+		}
+		lastErr = upstreamErr
+	}
+
+	return lastErr
+}
+
+// fetchOnce makes a single attempt and classifies the outcome
+func (c *FinnhubClient) fetchOnce(ctx context.Context, path string, params url.Values, out interface{}) (err error) {
+	// Checked before the key pool so an open circuit never waits on a limiter
+	if err := c.breaker.Allow(); err != nil {
+		return err
+	}
+	defer func() {
+		c.breaker.Done(err)
+	}()
+
+	key, err := c.keys.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) || ctx.Err() != nil {
+			return err
+		}
+		// No key will turn up by waiting, so this isn't retried
+		if errors.Is(err, ErrNoAPIKeys) {
+			return &UpstreamError{Kind: KindNotConfigured, Message: "upstream not configured", Err: err}
+		}
+		return &UpstreamError{Kind: KindRateLimited, Message: "rate limit exceeded", Err: err}
+	}
+
+	params.Set("token", key)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return &UpstreamError{Kind: KindUpstreamDown, Message: "upstream request failed", Err: redactError(err)}
 	}
 	defer resp.Body.Close()
 
-	var searchResp map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-		return nil, err
-	}
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
 
-	results := make([]models.SearchResult, 0)
-	if resultList, ok := searchResp["result"].([]interface{}); ok {
-		for i, item := range resultList {
-			if i >= 20 { // Limit to 20 results
-				break
-			}
-			if itemMap, ok := item.(map[string]interface{}); ok {
-				result := models.SearchResult{
-					Symbol:      getString(itemMap, "symbol"),
-					Description: getString(itemMap, "description"),
-					Type:        getString(itemMap, "type"),
-				}
-				results = append(results, result)
-			}
-		}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests:
+		c.keys.Bench(key, retryAfter)
+		return &UpstreamError{Kind: KindRateLimited, Status: resp.StatusCode, RetryAfter: retryAfter, Message: "upstream rate limit exceeded"}
+	case resp.StatusCode == http.StatusNotFound:
+		return &UpstreamError{Kind: KindNotFound, Status: resp.StatusCode, Message: "not found upstream"}
+	default:
+		return &UpstreamError{Kind: KindUpstreamDown, Status: resp.StatusCode, RetryAfter: retryAfter, Message: "upstream request failed"}
 	}
 
-	// Cache the result
-	if data, err := json.Marshal(results); err == nil {
-		c.cache.Set(ctx, cacheKey, data, cache.SearchTTL)
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &UpstreamError{Kind: KindBadPayload, Message: "malformed upstream payload", Err: err}
 	}
 
-	return results, nil
+	return nil
 }
 
 // generateMockOrderBook creates realistic mock order book data with dynamic volumes
@@ -410,6 +484,40 @@ func (c *FinnhubClient) generateMockOrderBook(symbol string, basePrice float64)
 }
 
 // Helper functions
+func retryBackoff(attempt int) time.Duration {
+	backoff := retryBaseDelay << uint(attempt-1)
+	if backoff > retryMaxDelay {
+		backoff = retryMaxDelay
+	}
+	// Jitter between half and the full backoff
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// redactError strips the API key from errors that embed the request URL
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			return &url.Error{Op: urlErr.Op, URL: redactURL(u).String(), Err: urlErr.Err}
+		}
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. A date already past means no wait rather than a negative one.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
 func getFloat64(data map[string]interface{}, key string) float64 {
 	if val, ok := data[key]; ok {
 		switch v := val.(type) {
@@ -431,4 +539,11 @@ func getString(data map[string]interface{}, key string) string {
 		}
 	}
 	return ""
-}
\ No newline at end of file
+}
+
+// articleID derives a stable ID for a news article without one of its own
+func articleID(symbol string, at time.Time, headline string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d|%s", symbol, at.Unix(), headline)
+	return fmt.Sprintf("%s-%x", symbol, h.Sum64())
+}
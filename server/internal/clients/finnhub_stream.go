@@ -0,0 +1,343 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Reconnect backoff bounds for the upstream trade feed
+	streamMinBackoff = 1 * time.Second
+	streamMaxBackoff = 60 * time.Second
+
+	// Finnhub pings roughly every few seconds; treat a longer silence as dead
+	streamReadTimeout = 60 * time.Second
+	streamWriteWait   = 10 * time.Second
+
+	// Trades buffered for the consumer before new ones are dropped
+	streamTradeBuffer = 1024
+)
+
+// errStreamIdle ends a connection that no longer has symbols to stream
+var errStreamIdle = errors.New("no symbols to stream")
+
+// FinnhubStream keeps a single WebSocket connection to Finnhub's trade feed
+// and mirrors the current symbol set onto it across reconnects. It is only
+// connected while it has symbols, so an instance with nothing to stream,
+// such as a cluster follower, holds no upstream connection.
+type FinnhubStream struct {
+	url    string
+	dialer *websocket.Dialer
+	trades chan models.Trade
+
+	// Desired subscriptions, replayed after every reconnect
+	symbols map[string]bool
+	conn    *websocket.Conn
+	mutex   sync.Mutex
+
+	// Subscription changes not yet written, true to subscribe, and a
+	// signal that there are some. Only the connection's writer goroutine
+	// writes, so callers never wait on the network.
+	pending map[string]bool
+	changed chan struct{}
+
+	connected bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// finnhubStreamMessage covers both control and trade messages on the feed
+type finnhubStreamMessage struct {
+	Type string `json:"type"`
+	Msg  string `json:"msg,omitempty"`
+	Data []struct {
+		Symbol    string  `json:"s"`
+		Price     float64 `json:"p"`
+		Volume    float64 `json:"v"`
+		Timestamp int64   `json:"t"`
+	} `json:"data,omitempty"`
+}
+
+// NewFinnhubStream creates a trade stream for the given feed URL and API key
+func NewFinnhubStream(streamURL, apiKey string) *FinnhubStream {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	u, err := url.Parse(streamURL)
+	if err == nil {
+		q := u.Query()
+		q.Set("token", apiKey)
+		u.RawQuery = q.Encode()
+		streamURL = u.String()
+	}
+
+	return &FinnhubStream{
+		url: streamURL,
+		dialer: &websocket.Dialer{
+			HandshakeTimeout: 10 * time.Second,
+		},
+		trades:  make(chan models.Trade, streamTradeBuffer),
+		symbols: make(map[string]bool),
+		pending: make(map[string]bool),
+		changed: make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Run connects to the feed whenever there are symbols to stream and
+// reconnects with backoff until Shutdown
+func (s *FinnhubStream) Run() {
+	backoff := streamMinBackoff
+
+	for {
+		if !s.waitForSymbols() {
+			return
+		}
+
+		connectedAt := time.Now()
+		err := s.connectAndRead()
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err == errStreamIdle {
+			log.Printf("Finnhub stream disconnected: %v", err)
+			backoff = streamMinBackoff
+			continue
+		}
+		if err != nil {
+			log.Printf("Finnhub stream disconnected: %v", err)
+		}
+
+		// A connection that stayed up for a while resets the backoff
+		if time.Since(connectedAt) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+
+		// Full jitter keeps many instances from reconnecting in lockstep
+		wait := time.Duration(rand.Int63n(int64(backoff))) + streamMinBackoff/2
+		select {
+		case <-time.After(wait):
+		case <-s.ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// waitForSymbols blocks until there is a symbol to stream, returning false
+// once the stream is shut down
+func (s *FinnhubStream) waitForSymbols() bool {
+	for {
+		s.mutex.Lock()
+		count := len(s.symbols)
+		s.mutex.Unlock()
+		if count > 0 {
+			return true
+		}
+
+		select {
+		case <-s.changed:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+}
+
+// Shutdown closes the connection and stops reconnecting
+func (s *FinnhubStream) Shutdown() {
+	s.cancel()
+
+	s.mutex.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mutex.Unlock()
+}
+
+// Subscribe adds a symbol to the feed. It doesn't block; the change is
+// written to the connection in the background.
+func (s *FinnhubStream) Subscribe(symbol string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.symbols[symbol] {
+		return
+	}
+	s.symbols[symbol] = true
+	s.queueChange(symbol, true)
+}
+
+// Unsubscribe removes a symbol from the feed without blocking
+func (s *FinnhubStream) Unsubscribe(symbol string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.symbols[symbol] {
+		return
+	}
+	delete(s.symbols, symbol)
+	s.queueChange(symbol, false)
+}
+
+// queueChange hands a subscription change to the writer. The caller holds
+// s.mutex.
+func (s *FinnhubStream) queueChange(symbol string, subscribe bool) {
+	s.pending[symbol] = subscribe
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Connected reports whether the upstream connection is currently open
+func (s *FinnhubStream) Connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connected
+}
+
+// Trades returns the channel trades are delivered on
+func (s *FinnhubStream) Trades() <-chan models.Trade {
+	return s.trades
+}
+
+func (s *FinnhubStream) connectAndRead() error {
+	conn, _, err := s.dialer.DialContext(s.ctx, s.url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Register the connection and replay current subscriptions, replacing
+	// whatever changes were queued while disconnected
+	s.mutex.Lock()
+	s.conn = conn
+	s.connected = true
+	s.pending = make(map[string]bool, len(s.symbols))
+	for symbol := range s.symbols {
+		s.queueChange(symbol, true)
+	}
+	count := len(s.symbols)
+	s.mutex.Unlock()
+
+	log.Printf("Finnhub stream connected, %d symbols subscribed", count)
+
+	done := make(chan struct{})
+	idle := make(chan struct{})
+	defer func() {
+		close(done)
+		s.mutex.Lock()
+		s.conn = nil
+		s.connected = false
+		s.mutex.Unlock()
+	}()
+	go s.writeChanges(conn, done, idle)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-idle:
+				return errStreamIdle
+			default:
+				return err
+			}
+		}
+		s.handleMessage(message)
+	}
+}
+
+func (s *FinnhubStream) handleMessage(message []byte) {
+	var msg finnhubStreamMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		log.Printf("Error parsing Finnhub stream message: %v", err)
+		return
+	}
+
+	switch msg.Type {
+	case "trade":
+		for _, t := range msg.Data {
+			trade := models.Trade{
+				Symbol:    t.Symbol,
+				Price:     t.Price,
+				Volume:    t.Volume,
+				Timestamp: time.UnixMilli(t.Timestamp),
+			}
+			select {
+			case s.trades <- trade:
+			default:
+				// Consumer is behind; newer trades will follow shortly
+			}
+		}
+
+	case "ping":
+		// Keepalive, the read deadline has already been extended
+
+	case "error":
+		log.Printf("Finnhub stream error: %s", msg.Msg)
+	}
+}
+
+// writeChanges writes queued subscription changes to conn until done is
+// closed. A failed write closes conn, so the error surfaces on the read
+// side and the stream reconnects. Once no symbols are left it closes idle
+// and then conn.
+func (s *FinnhubStream) writeChanges(conn *websocket.Conn, done <-chan struct{}, idle chan<- struct{}) {
+	for {
+		select {
+		case <-s.changed:
+		case <-done:
+			return
+		}
+
+		s.mutex.Lock()
+		changes := s.pending
+		s.pending = make(map[string]bool)
+		empty := len(s.symbols) == 0
+		s.mutex.Unlock()
+
+		if empty {
+			close(idle)
+			conn.Close()
+			return
+		}
+
+		for symbol, subscribe := range changes {
+			msgType := "unsubscribe"
+			if subscribe {
+				msgType = "subscribe"
+			}
+			if err := s.send(conn, msgType, symbol); err != nil {
+				log.Printf("Finnhub stream %s %s failed: %v", msgType, symbol, err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// send writes a subscription command
+func (s *FinnhubStream) send(conn *websocket.Conn, msgType, symbol string) error {
+	data, err := json.Marshal(map[string]string{"type": msgType, "symbol": symbol})
+	if err != nil {
+		return err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
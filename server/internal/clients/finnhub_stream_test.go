@@ -0,0 +1,190 @@
+package clients
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"equity-server/internal/fakefinnhub"
+)
+
+// feedListener counts the connections made to a fake feed and can drop
+// them all, which hijacked WebSocket connections otherwise don't allow
+type feedListener struct {
+	net.Listener
+
+	mutex sync.Mutex
+	conns []net.Conn
+	dials int
+}
+
+func (l *feedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mutex.Lock()
+		l.conns = append(l.conns, conn)
+		l.dials++
+		l.mutex.Unlock()
+	}
+	return conn, err
+}
+
+func (l *feedListener) dialCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.dials
+}
+
+func (l *feedListener) dropAll() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+// startFeed runs a fake Finnhub and a stream connected to its trade feed
+func startFeed(t *testing.T) (*fakefinnhub.Server, *feedListener, *FinnhubStream) {
+	t.Helper()
+	fake := fakefinnhub.New()
+	srv := httptest.NewUnstartedServer(fake)
+	listener := &feedListener{Listener: srv.Listener}
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	stream := NewFinnhubStream("ws"+strings.TrimPrefix(srv.URL, "http")+fakefinnhub.StreamPath, "test-key")
+	go stream.Run()
+	t.Cleanup(stream.Shutdown)
+	return fake, listener, stream
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// subscribedTo reports whether the feed has exactly symbols subscribed
+func subscribedTo(fake *fakefinnhub.Server, symbols ...string) func() bool {
+	sort.Strings(symbols)
+	return func() bool {
+		got := fake.StreamSubscribers()
+		return len(got) == len(symbols) && (len(got) == 0 || reflect.DeepEqual(got, symbols))
+	}
+}
+
+func TestFinnhubStreamDeliversTrades(t *testing.T) {
+	fake, listener, stream := startFeed(t)
+
+	// Nothing to stream, so no connection
+	time.Sleep(100 * time.Millisecond)
+	if n := listener.dialCount(); n != 0 {
+		t.Fatalf("stream dialed %d times without symbols", n)
+	}
+
+	stream.Subscribe("AAPL")
+	stream.Subscribe("AAPL")
+	waitFor(t, "AAPL subscription", subscribedTo(fake, "AAPL"))
+	if !stream.Connected() {
+		t.Fatal("stream not connected after subscribing")
+	}
+
+	fake.PublishTrade("AAPL", 187.5, 100)
+	select {
+	case trade := <-stream.Trades():
+		if trade.Symbol != "AAPL" || trade.Price != 187.5 || trade.Volume != 100 {
+			t.Fatalf("trade = %+v, want AAPL 100 @ 187.5", trade)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a trade")
+	}
+}
+
+func TestFinnhubStreamDisconnectsWhenIdle(t *testing.T) {
+	fake, listener, stream := startFeed(t)
+
+	stream.Subscribe("AAPL")
+	stream.Subscribe("MSFT")
+	waitFor(t, "both subscriptions", subscribedTo(fake, "AAPL", "MSFT"))
+
+	stream.Unsubscribe("MSFT")
+	waitFor(t, "MSFT unsubscribe", subscribedTo(fake, "AAPL"))
+
+	stream.Unsubscribe("AAPL")
+	waitFor(t, "idle disconnect", func() bool { return !stream.Connected() })
+	waitFor(t, "feed to drop the connection", subscribedTo(fake))
+
+	// A new symbol reconnects straight away, without backoff
+	start := time.Now()
+	stream.Subscribe("TSLA")
+	waitFor(t, "TSLA subscription", subscribedTo(fake, "TSLA"))
+	if wait := time.Since(start); wait >= streamMinBackoff/2 {
+		t.Fatalf("reconnecting after idle took %v", wait)
+	}
+	if n := listener.dialCount(); n != 2 {
+		t.Fatalf("stream dialed %d times, want 2", n)
+	}
+}
+
+func TestFinnhubStreamResubscribesAfterReconnect(t *testing.T) {
+	fake, listener, stream := startFeed(t)
+
+	stream.Subscribe("AAPL")
+	stream.Subscribe("MSFT")
+	waitFor(t, "both subscriptions", subscribedTo(fake, "AAPL", "MSFT"))
+
+	listener.dropAll()
+	waitFor(t, "disconnect", func() bool { return !stream.Connected() })
+
+	// Changes while disconnected are applied on reconnect
+	stream.Unsubscribe("MSFT")
+	stream.Subscribe("TSLA")
+	waitFor(t, "reconnect", func() bool { return listener.dialCount() == 2 && stream.Connected() })
+	waitFor(t, "subscriptions replayed", subscribedTo(fake, "AAPL", "TSLA"))
+}
+
+// TestFinnhubStreamConcurrentChanges subscribes and unsubscribes from many
+// goroutines, for go test -race. The feed must end up with the final set.
+func TestFinnhubStreamConcurrentChanges(t *testing.T) {
+	fake, _, stream := startFeed(t)
+	stream.Subscribe("SPY")
+	waitFor(t, "SPY subscription", subscribedTo(fake, "SPY"))
+
+	var wg sync.WaitGroup
+	want := []string{"SPY"}
+	for i := 0; i < 10; i++ {
+		symbol := fmt.Sprintf("SYM%d", i)
+		keep := i%2 == 0
+		if keep {
+			want = append(want, symbol)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				stream.Subscribe(symbol)
+				stream.Unsubscribe(symbol)
+			}
+			if keep {
+				stream.Subscribe(symbol)
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, "final subscriptions", subscribedTo(fake, want...))
+}
@@ -0,0 +1,138 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"equity-server/internal/cache"
+	"equity-server/internal/fakefinnhub"
+)
+
+// newTestFinnhub returns a fake Finnhub and a client pointed at it, with an
+// empty cache and the given API keys
+func newTestFinnhub(t *testing.T, keys ...string) (*fakefinnhub.Server, *FinnhubClient) {
+	t.Helper()
+	fake := fakefinnhub.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	serializer, err := cache.NewSerializer(cache.MessagePack, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := cache.NewStore(cache.NewMemoryCache(cache.MemoryCacheOptions{}), "test", serializer)
+	pool := NewKeyPool(keys, 6000, time.Minute)
+	return fake, NewFinnhubClient(pool, srv.URL+fakefinnhub.APIPrefix, store, nil, nil)
+}
+
+func TestFinnhubClientQuote(t *testing.T) {
+	fake, client := newTestFinnhub(t, "key")
+	fake.Program(fakefinnhub.EndpointQuote, "AAPL", fakefinnhub.Response{
+		Body: map[string]float64{"c": 190.5, "d": 1.5, "dp": 0.79, "h": 191, "l": 188, "o": 189, "pc": 189},
+	})
+
+	ctx := context.Background()
+	quote, err := client.GetQuote(ctx, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Symbol != "AAPL" || quote.CurrentPrice != 190.5 || quote.PreviousClose != 189 || quote.Stale {
+		t.Fatalf("quote = %+v", quote)
+	}
+
+	// The second request is served from the cache
+	if _, err := client.GetQuote(ctx, "AAPL"); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Requests(fakefinnhub.EndpointQuote); n != 1 {
+		t.Fatalf("upstream served %d quote requests, want 1", n)
+	}
+}
+
+func TestFinnhubClientRetriesRateLimitWithAnotherKey(t *testing.T) {
+	fake, client := newTestFinnhub(t, "first", "second")
+	fake.RateLimit(1)
+
+	if _, err := client.GetQuote(context.Background(), "AAPL"); err != nil {
+		t.Fatalf("GetQuote after one 429 = %v, want success", err)
+	}
+	if n := fake.Requests(fakefinnhub.EndpointQuote); n != 2 {
+		t.Fatalf("upstream served %d quote requests, want 2", n)
+	}
+}
+
+func TestFinnhubClientClassifiesErrors(t *testing.T) {
+	fake, client := newTestFinnhub(t, "key")
+
+	tests := []struct {
+		symbol string
+		resp   fakefinnhub.Response
+		want   error
+	}{
+		{"MALFORMED", fakefinnhub.Response{Raw: `{"c": `}, ErrBadPayload},
+		{"MISSING", fakefinnhub.Response{Status: http.StatusNotFound, Body: map[string]string{}}, ErrNotFound},
+		{"ZERO", fakefinnhub.Response{Body: map[string]float64{"c": 0, "pc": 0}}, ErrNotFound},
+		{"DENIED", fakefinnhub.Response{Status: http.StatusForbidden, Body: map[string]string{}}, ErrUpstreamDown},
+	}
+
+	for _, tt := range tests {
+		fake.Program(fakefinnhub.EndpointQuote, tt.symbol, tt.resp)
+		_, err := client.GetQuote(context.Background(), tt.symbol)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("GetQuote(%s) = %v, want %v", tt.symbol, err, tt.want)
+		}
+	}
+
+	// None of these is worth retrying
+	if n := fake.Requests(fakefinnhub.EndpointQuote); n != len(tests) {
+		t.Fatalf("upstream served %d quote requests, want %d", n, len(tests))
+	}
+}
+
+func TestFinnhubClientWithoutKeys(t *testing.T) {
+	fake, client := newTestFinnhub(t)
+
+	start := time.Now()
+	_, err := client.GetQuote(context.Background(), "AAPL")
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("GetQuote without keys = %v, want %v", err, ErrNotConfigured)
+	}
+	if errors.Is(err, ErrRateLimited) {
+		t.Fatal("an empty key pool was reported as rate limited")
+	}
+
+	// Waiting won't produce a key, so it fails without retrying
+	if wait := time.Since(start); wait >= retryBaseDelay/2 {
+		t.Fatalf("GetQuote without keys took %v", wait)
+	}
+	if n := fake.Requests(fakefinnhub.EndpointQuote); n != 0 {
+		t.Fatalf("upstream served %d quote requests, want none", n)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"30", 30 * time.Second, 30 * time.Second},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{future, 58 * time.Second, time.Minute},
+		{past, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package clients
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fixture modes for upstream HTTP traffic
+const (
+	FixtureModeLive   = "live"
+	FixtureModeRecord = "record"
+	FixtureModeReplay = "replay"
+)
+
+// redacted replaces credentials in recorded fixtures
+const redacted = "REDACTED"
+
+// Query parameters and headers that carry credentials
+var (
+	secretParams  = []string{"token"}
+	secretHeaders = []string{"X-Finnhub-Token", "Authorization"}
+)
+
+// Query parameters that change on every request (candle ranges default to
+// "now"), ignored when replay falls back to a loose match
+var volatileParams = []string{"from", "to"}
+
+// Fixture is one recorded upstream request and response
+type Fixture struct {
+	RecordedAt time.Time       `json:"recordedAt"`
+	Request    FixtureRequest  `json:"request"`
+	Response   FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the redacted request that produced a fixture
+type FixtureRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// FixtureResponse is the recorded upstream response. JSON bodies are kept
+// inline for readability; anything else is stored as text.
+type FixtureResponse struct {
+	Status  int             `json:"status"`
+	Header  http.Header     `json:"header,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	RawBody string          `json:"rawBody,omitempty"`
+}
+
+// NewFixtureTransport returns the transport for the given fixture mode.
+// Live mode returns nil so callers keep their default transport.
+func NewFixtureTransport(mode, dir string) (http.RoundTripper, error) {
+	switch mode {
+	case "", FixtureModeLive:
+		return nil, nil
+	case FixtureModeRecord:
+		return NewRecordingTransport(dir, http.DefaultTransport)
+	case FixtureModeReplay:
+		return NewReplayTransport(dir)
+	default:
+		return nil, fmt.Errorf("unknown fixture mode %q", mode)
+	}
+}
+
+// RecordingTransport forwards requests upstream and writes each exchange to
+// the fixture directory with credentials redacted
+type RecordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// NewRecordingTransport creates a recording transport writing into dir
+func NewRecordingTransport(dir string, next http.RoundTripper) (*RecordingTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create fixture dir: %w", err)
+	}
+	return &RecordingTransport{dir: dir, next: next}, nil
+}
+
+// RoundTrip performs the request and records the response
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fixture := Fixture{
+		RecordedAt: time.Now().UTC(),
+		Request: FixtureRequest{
+			Method: req.Method,
+			URL:    redactURL(req.URL).String(),
+		},
+		Response: FixtureResponse{
+			Status: resp.StatusCode,
+			Header: redactHeader(resp.Header),
+		},
+	}
+	if json.Valid(body) {
+		fixture.Response.Body = body
+	} else {
+		fixture.Response.RawBody = string(body)
+	}
+
+	// Recording is best effort; the live response is returned regardless
+	if err := t.write(req, &fixture); err != nil {
+		log.Printf("Failed to record fixture for %s: %v", fixture.Request.URL, err)
+	}
+
+	return resp, nil
+}
+
+func (t *RecordingTransport) write(req *http.Request, fixture *Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a concurrent replay never sees a partial file
+	path := filepath.Join(t.dir, fixtureFileName(req.Method, req.URL))
+	tmp, err := os.CreateTemp(t.dir, ".fixture-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReplayTransport serves recorded fixtures and never touches the network.
+// Fixtures are loaded once and read-only afterwards.
+type ReplayTransport struct {
+	exact map[string]*Fixture
+	loose map[string]*Fixture
+}
+
+// NewReplayTransport loads every fixture in dir
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &ReplayTransport{
+		exact: make(map[string]*Fixture),
+		loose: make(map[string]*Fixture),
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("parse fixture %s: %w", file, err)
+		}
+
+		u, err := url.Parse(fixture.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse fixture %s: %w", file, err)
+		}
+
+		t.exact[fixtureKey(fixture.Request.Method, u, false)] = &fixture
+
+		// Keep the newest recording for each loose key
+		looseKey := fixtureKey(fixture.Request.Method, u, true)
+		if existing, ok := t.loose[looseKey]; !ok || fixture.RecordedAt.After(existing.RecordedAt) {
+			t.loose[looseKey] = &fixture
+		}
+	}
+
+	return t, nil
+}
+
+// RoundTrip answers from the loaded fixtures
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fixture, ok := t.exact[fixtureKey(req.Method, req.URL, false)]
+	if !ok {
+		fixture, ok = t.loose[fixtureKey(req.Method, req.URL, true)]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no fixture recorded for %s %s", req.Method, redactURL(req.URL))
+	}
+
+	body := []byte(fixture.Response.Body)
+	if len(body) == 0 {
+		body = []byte(fixture.Response.RawBody)
+	}
+
+	// Bodies are re-indented on disk, so the recorded length no longer applies
+	header := fixture.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Response.Status, http.StatusText(fixture.Response.Status)),
+		StatusCode:    fixture.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// fixtureKey identifies a request by method, path and sorted query with
+// credentials removed; loose keys also drop volatile parameters
+func fixtureKey(method string, u *url.URL, loose bool) string {
+	query := u.Query()
+	for _, param := range secretParams {
+		query.Del(param)
+	}
+	if loose {
+		for _, param := range volatileParams {
+			query.Del(param)
+		}
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteString(" ")
+	b.WriteString(u.Path)
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			b.WriteString("&" + key + "=" + value)
+		}
+	}
+	return b.String()
+}
+
+// fixtureFileName builds a readable, collision-resistant file name
+func fixtureFileName(method string, u *url.URL) string {
+	sum := sha256.Sum256([]byte(fixtureKey(method, u, false)))
+
+	name := strings.Trim(strings.ReplaceAll(u.Path, "/", "-"), "-")
+	if symbol := u.Query().Get("symbol"); symbol != "" {
+		name += "-" + symbol
+	} else if q := u.Query().Get("q"); q != "" {
+		name += "-" + q
+	}
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	return name + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+func redactURL(u *url.URL) *url.URL {
+	clean := *u
+	query := clean.Query()
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+		}
+	}
+	clean.RawQuery = query.Encode()
+	return &clean
+}
+
+func redactHeader(header http.Header) http.Header {
+	clean := header.Clone()
+	for _, name := range secretHeaders {
+		if clean.Get(name) != "" {
+			clean.Set(name, redacted)
+		}
+	}
+	return clean
+}
@@ -0,0 +1,193 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrNoAPIKeys is returned when a key pool is empty
+var ErrNoAPIKeys = errors.New("no API keys configured")
+
+// KeyPool spreads upstream requests across several API keys, each with its
+// own rate limiter. Keys that get rate limited upstream are benched.
+type KeyPool struct {
+	keys     []*pooledKey
+	benchFor time.Duration
+	mutex    sync.Mutex
+}
+
+// pooledKey is one API key and its usage
+type pooledKey struct {
+	value        string
+	limiter      *rate.Limiter
+	requests     int64
+	rateLimited  int64
+	benchedUntil time.Time
+	lastUsed     time.Time
+}
+
+// KeyUsage is a snapshot of one key's usage, with the key masked
+type KeyUsage struct {
+	Key          string    `json:"key"`
+	Requests     int64     `json:"requests"`
+	RateLimited  int64     `json:"rateLimited"`
+	Headroom     float64   `json:"headroom"`
+	Benched      bool      `json:"benched"`
+	BenchedUntil time.Time `json:"benchedUntil,omitempty"`
+	LastUsed     time.Time `json:"lastUsed,omitempty"`
+}
+
+// NewKeyPool creates a pool allowing perMinute requests per key, with a
+// burst of a sixth of that, and benching rate-limited keys for benchFor
+func NewKeyPool(keys []string, perMinute int, benchFor time.Duration) *KeyPool {
+	if perMinute <= 0 {
+		perMinute = 60
+	}
+	burst := perMinute / 6
+	if burst < 1 {
+		burst = 1
+	}
+
+	pool := &KeyPool{benchFor: benchFor}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		pool.keys = append(pool.keys, &pooledKey{
+			value:   key,
+			limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), burst),
+		})
+	}
+
+	return pool
+}
+
+// Acquire picks the key with the most headroom, waits for its limiter and
+// returns it. When every key is benched it fails fast with a rate-limited
+// error whose RetryAfter says when the first key returns.
+func (p *KeyPool) Acquire(ctx context.Context) (string, error) {
+	key, wait, err := p.pick()
+	if err != nil {
+		return "", err
+	}
+
+	if key == nil {
+		return "", &UpstreamError{
+			Kind:       KindRateLimited,
+			RetryAfter: wait,
+			Message:    "all API keys are rate limited",
+		}
+	}
+
+	if err := key.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+
+	p.mutex.Lock()
+	key.requests++
+	key.lastUsed = time.Now()
+	p.mutex.Unlock()
+
+	return key.value, nil
+}
+
+// Primary returns the first configured key, for connections that need one
+// fixed key such as the trade stream
+func (p *KeyPool) Primary() string {
+	if len(p.keys) == 0 {
+		return ""
+	}
+	return p.keys[0].value
+}
+
+// Bench takes a key out of rotation after the upstream rate limited it.
+// A retryAfter hint longer than the pool's bench period takes precedence.
+func (p *KeyPool) Bench(value string, retryAfter time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	benchFor := p.benchFor
+	if retryAfter > benchFor {
+		benchFor = retryAfter
+	}
+
+	for _, key := range p.keys {
+		if key.value == value {
+			key.rateLimited++
+			key.benchedUntil = time.Now().Add(benchFor)
+			return
+		}
+	}
+}
+
+// Usage returns a masked usage snapshot for every key
+func (p *KeyPool) Usage() []KeyUsage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	usage := make([]KeyUsage, len(p.keys))
+	for i, key := range p.keys {
+		usage[i] = KeyUsage{
+			Key:         maskKey(key.value),
+			Requests:    key.requests,
+			RateLimited: key.rateLimited,
+			Headroom:    key.limiter.TokensAt(now),
+			Benched:     now.Before(key.benchedUntil),
+			LastUsed:    key.lastUsed,
+		}
+		if usage[i].Benched {
+			usage[i].BenchedUntil = key.benchedUntil
+		}
+	}
+
+	return usage
+}
+
+// pick returns the available key with the most limiter tokens, or how long
+// to wait until a benched key becomes available
+func (p *KeyPool) pick() (*pooledKey, time.Duration, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.keys) == 0 {
+		return nil, 0, ErrNoAPIKeys
+	}
+
+	now := time.Now()
+	var best *pooledKey
+	var bestTokens float64
+	soonest := time.Duration(-1)
+
+	for _, key := range p.keys {
+		if now.Before(key.benchedUntil) {
+			if wait := key.benchedUntil.Sub(now); soonest < 0 || wait < soonest {
+				soonest = wait
+			}
+			continue
+		}
+
+		tokens := key.limiter.TokensAt(now)
+		if best == nil || tokens > bestTokens {
+			best = key
+			bestTokens = tokens
+		}
+	}
+
+	return best, soonest, nil
+}
+
+// maskKey keeps just enough of a key to tell keys apart
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
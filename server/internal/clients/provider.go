@@ -0,0 +1,53 @@
+package clients
+
+import (
+	"context"
+
+	"equity-server/internal/models"
+)
+
+// MarketDataProvider is the set of market data operations the handlers and
+// the WebSocket hub depend on. FinnhubClient is the default implementation.
+type MarketDataProvider interface {
+	GetQuote(ctx context.Context, symbol string) (*models.Quote, error)
+	GetCandles(ctx context.Context, symbol, resolution string, from, to int64) (*models.CandleData, error)
+	GetProfile(ctx context.Context, symbol string) (*models.CompanyProfile, error)
+	GetNews(ctx context.Context, symbol, from, to string) ([]models.NewsItem, error)
+	GetOrderBook(ctx context.Context, symbol string) (*models.OrderBook, error)
+	SearchSymbols(ctx context.Context, query string) ([]models.SearchResult, error)
+}
+
+// Provider names accepted by the MARKET_DATA_PROVIDER setting
+const (
+	ProviderFinnhub   = "finnhub"
+	ProviderSimulator = "simulator"
+)
+
+// Ensure implementations satisfy their interfaces
+var (
+	_ MarketDataProvider = (*FinnhubClient)(nil)
+	_ TradeStream        = (*FinnhubStream)(nil)
+	_ MarketDataProvider = (*SimulatorProvider)(nil)
+	_ TradeStream        = (*SimulatorProvider)(nil)
+	_ MarketDataProvider = (*FailoverProvider)(nil)
+	_ CircuitReporter    = (*FinnhubClient)(nil)
+)
+
+// CircuitReporter is implemented by providers that sit behind a circuit
+// breaker, so its state can be reported alongside their health
+type CircuitReporter interface {
+	CircuitStatus() BreakerStatus
+}
+
+// TradeStream delivers live trades for a changing set of symbols. Run blocks
+// until Shutdown is called; Connected reports whether trades are flowing.
+// Subscribe and Unsubscribe must not block, since the hub calls them while
+// holding subscription locks.
+type TradeStream interface {
+	Run()
+	Shutdown()
+	Subscribe(symbol string)
+	Unsubscribe(symbol string)
+	Connected() bool
+	Trades() <-chan models.Trade
+}
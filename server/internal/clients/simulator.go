@@ -0,0 +1,486 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+const (
+	// Trading seconds in a year, used to scale annualized drift and volatility
+	secondsPerTradingYear = 252 * 6.5 * 60 * 60
+
+	// Upper bound on generated candles per request
+	maxSimulatedCandles = 5000
+
+	// Gaps longer than this many ticks are crossed in one draw rather than
+	// walked, so a symbol first requested after a long uptime is as cheap
+	// as one requested a moment ago
+	maxWalkedTicks = 1000
+)
+
+// simulatedUniverse is what SearchSymbols matches against
+var simulatedUniverse = []models.SearchResult{
+	{Symbol: "AAPL", Description: "APPLE INC", Type: "Common Stock"},
+	{Symbol: "AMZN", Description: "AMAZON.COM INC", Type: "Common Stock"},
+	{Symbol: "AMD", Description: "ADVANCED MICRO DEVICES", Type: "Common Stock"},
+	{Symbol: "GOOGL", Description: "ALPHABET INC-CL A", Type: "Common Stock"},
+	{Symbol: "IBM", Description: "INTL BUSINESS MACHINES CORP", Type: "Common Stock"},
+	{Symbol: "INTC", Description: "INTEL CORP", Type: "Common Stock"},
+	{Symbol: "JPM", Description: "JPMORGAN CHASE & CO", Type: "Common Stock"},
+	{Symbol: "META", Description: "META PLATFORMS INC-CLASS A", Type: "Common Stock"},
+	{Symbol: "MSFT", Description: "MICROSOFT CORP", Type: "Common Stock"},
+	{Symbol: "NFLX", Description: "NETFLIX INC", Type: "Common Stock"},
+	{Symbol: "NVDA", Description: "NVIDIA CORP", Type: "Common Stock"},
+	{Symbol: "QQQ", Description: "INVESCO QQQ TRUST SERIES 1", Type: "ETP"},
+	{Symbol: "SPY", Description: "SPDR S&P 500 ETF TRUST", Type: "ETP"},
+	{Symbol: "TSLA", Description: "TESLA INC", Type: "Common Stock"},
+	{Symbol: "V", Description: "VISA INC-CLASS A SHARES", Type: "Common Stock"},
+}
+
+var simulatedIndustries = []string{
+	"Technology", "Semiconductors", "Banking", "Retail", "Media",
+	"Pharmaceuticals", "Automobiles", "Energy", "Telecommunication",
+}
+
+var simulatedHeadlines = []string{
+	"%s shares move as analysts revise price targets",
+	"%s announces date for quarterly earnings call",
+	"Institutional investors adjust positions in %s",
+	"%s unveils new product roadmap at investor day",
+	"Options activity picks up in %s ahead of expiration",
+	"%s management comments on supply chain outlook",
+}
+
+// SimulatorProvider generates synthetic market data with a seeded geometric
+// Brownian motion per symbol. Prices advance in fixed ticks measured from
+// start, so the same seed yields the same path for the same requests. Long
+// gaps between requests for a symbol are crossed in a single draw.
+type SimulatorProvider struct {
+	seed  int64
+	tick  time.Duration
+	start time.Time
+
+	symbols map[string]*simulatedSymbol
+	mutex   sync.Mutex
+
+	// Trade stream state
+	trades     chan models.Trade
+	subscribed map[string]bool
+	running    bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// simulatedSymbol holds the evolving state of one symbol
+type simulatedSymbol struct {
+	rng        *rand.Rand // drives the price path only
+	tradeRng   *rand.Rand // trade sizes, kept separate so the path stays reproducible
+	volatility float64    // annualized
+	drift      float64    // annualized
+	ticks      int64
+
+	price     float64
+	open      float64
+	high      float64
+	low       float64
+	prevClose float64
+}
+
+// NewSimulatorProvider creates a simulator with the given seed and tick size
+func NewSimulatorProvider(seed int64, tick time.Duration) *SimulatorProvider {
+	if tick <= 0 {
+		tick = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &SimulatorProvider{
+		seed:       seed,
+		tick:       tick,
+		start:      time.Now(),
+		symbols:    make(map[string]*simulatedSymbol),
+		trades:     make(chan models.Trade, streamTradeBuffer),
+		subscribed: make(map[string]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// GetQuote returns the current simulated quote
+func (s *SimulatorProvider) GetQuote(ctx context.Context, symbol string) (*models.Quote, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sym := s.advance(symbol, time.Now())
+	change := sym.price - sym.prevClose
+
+	return &models.Quote{
+		Symbol:        symbol,
+		CurrentPrice:  round2(sym.price),
+		Change:        round2(change),
+		PercentChange: round2(change / sym.prevClose * 100),
+		High:          round2(sym.high),
+		Low:           round2(sym.low),
+		Open:          round2(sym.open),
+		PreviousClose: round2(sym.prevClose),
+		Timestamp:     time.Now(),
+	}, nil
+}
+
+// GetCandles walks a GBM path backwards from the current price so the last
+// candle lines up with live quotes
+func (s *SimulatorProvider) GetCandles(ctx context.Context, symbol, resolution string, from, to int64) (*models.CandleData, error) {
+	step, err := resolutionDuration(resolution)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid range: from %d is after to %d", from, to)
+	}
+
+	stepSeconds := int64(step / time.Second)
+	count := (to-from)/stepSeconds + 1
+	if count > maxSimulatedCandles {
+		count = maxSimulatedCandles
+		from = to - (count-1)*stepSeconds
+	}
+
+	s.mutex.Lock()
+	sym := s.advance(symbol, time.Now())
+	closePrice := sym.price
+	volatility, drift := sym.volatility, sym.drift
+	s.mutex.Unlock()
+
+	rng := rand.New(rand.NewSource(s.symbolSeed(fmt.Sprintf("candles:%s:%s:%d", symbol, resolution, to/stepSeconds))))
+	dt := tradingYearFraction(resolution, step)
+	sigma := volatility * math.Sqrt(dt)
+
+	candles := &models.CandleData{
+		Symbol:     symbol,
+		Close:      make([]float64, count),
+		High:       make([]float64, count),
+		Low:        make([]float64, count),
+		Open:       make([]float64, count),
+		Volume:     make([]int64, count),
+		Timestamps: make([]int64, count),
+		Status:     "ok",
+	}
+
+	for i := count - 1; i >= 0; i-- {
+		ret := (drift-volatility*volatility/2)*dt + sigma*rng.NormFloat64()
+		openPrice := closePrice / math.Exp(ret)
+
+		high := math.Max(openPrice, closePrice) * (1 + math.Abs(rng.NormFloat64())*sigma/2)
+		low := math.Min(openPrice, closePrice) * (1 - math.Abs(rng.NormFloat64())*sigma/2)
+
+		candles.Timestamps[i] = from + i*stepSeconds
+		candles.Open[i] = round2(openPrice)
+		candles.Close[i] = round2(closePrice)
+		candles.High[i] = round2(high)
+		candles.Low[i] = round2(low)
+		candles.Volume[i] = int64(float64(100000+rng.Int63n(900000)) * (1 + math.Abs(ret)*50))
+
+		closePrice = openPrice
+	}
+
+	return candles, nil
+}
+
+// GetProfile returns a synthetic company profile
+func (s *SimulatorProvider) GetProfile(ctx context.Context, symbol string) (*models.CompanyProfile, error) {
+	s.mutex.Lock()
+	sym := s.advance(symbol, time.Now())
+	price := sym.price
+	s.mutex.Unlock()
+
+	rng := rand.New(rand.NewSource(s.symbolSeed("profile:" + symbol)))
+	shares := float64(50 + rng.Intn(5000)) // millions
+
+	name := symbol + " Holdings Inc"
+	for _, known := range simulatedUniverse {
+		if known.Symbol == symbol {
+			name = known.Description
+			break
+		}
+	}
+
+	return &models.CompanyProfile{
+		Symbol:               symbol,
+		Name:                 name,
+		Exchange:             "NASDAQ NMS - GLOBAL MARKET",
+		Industry:             simulatedIndustries[rng.Intn(len(simulatedIndustries))],
+		MarketCapitalization: round2(price * shares),
+		ShareOutstanding:     shares,
+		Logo:                 "",
+		WebURL:               "https://example.com/" + strings.ToLower(symbol),
+	}, nil
+}
+
+// GetNews returns a few synthetic headlines per day in the range
+func (s *SimulatorProvider) GetNews(ctx context.Context, symbol, from, to string) ([]models.NewsItem, error) {
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from date: %w", err)
+	}
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to date: %w", err)
+	}
+
+	news := make([]models.NewsItem, 0, 10)
+	for day := toDate; !day.Before(fromDate) && len(news) < 10; day = day.AddDate(0, 0, -1) {
+		rng := rand.New(rand.NewSource(s.symbolSeed(fmt.Sprintf("news:%s:%s", symbol, day.Format("2006-01-02")))))
+		for n := rng.Intn(3); n > 0 && len(news) < 10; n-- {
+			headline := fmt.Sprintf(simulatedHeadlines[rng.Intn(len(simulatedHeadlines))], symbol)
+			at := day.Add(time.Duration(9+rng.Intn(8)) * time.Hour)
+			id := articleID(symbol, at, headline)
+			news = append(news, models.NewsItem{
+				ID:       id,
+				Headline: headline,
+				Summary:  "Simulated market news generated for offline development.",
+				Source:   "Simulator",
+				URL:      "https://example.com/news/" + strings.ToLower(symbol) + "/" + id,
+				DateTime: at,
+				Symbol:   symbol,
+			})
+		}
+	}
+
+	return news, nil
+}
+
+// GetOrderBook builds ten levels per side around the current price
+func (s *SimulatorProvider) GetOrderBook(ctx context.Context, symbol string) (*models.OrderBook, error) {
+	s.mutex.Lock()
+	sym := s.advance(symbol, time.Now())
+	price := sym.price
+	ticks := sym.ticks
+	s.mutex.Unlock()
+
+	rng := rand.New(rand.NewSource(s.symbolSeed(fmt.Sprintf("book:%s:%d", symbol, ticks))))
+
+	// Spread widens a little for pricier symbols
+	tickSize := math.Max(0.01, round2(price*0.0001))
+
+	bids := make([]models.PriceLevel, 10)
+	asks := make([]models.PriceLevel, 10)
+	for i := 0; i < 10; i++ {
+		depth := 1 + float64(i)*0.35
+		bids[i] = models.PriceLevel{
+			Price:  round2(price - float64(i+1)*tickSize),
+			Volume: int64(float64(50+rng.Intn(400)) * depth),
+		}
+		asks[i] = models.PriceLevel{
+			Price:  round2(price + float64(i+1)*tickSize),
+			Volume: int64(float64(50+rng.Intn(400)) * depth),
+		}
+	}
+
+	return &models.OrderBook{
+		Symbol: symbol,
+		Bids:   bids,
+		Asks:   asks,
+	}, nil
+}
+
+// SearchSymbols matches the query against the simulated universe
+func (s *SimulatorProvider) SearchSymbols(ctx context.Context, query string) ([]models.SearchResult, error) {
+	q := strings.ToUpper(strings.TrimSpace(query))
+
+	results := make([]models.SearchResult, 0)
+	for _, item := range simulatedUniverse {
+		if strings.Contains(item.Symbol, q) || strings.Contains(item.Description, q) {
+			results = append(results, item)
+		}
+	}
+
+	// Any ticker-looking query resolves, since every symbol can be simulated
+	if len(results) == 0 && q != "" && len(q) <= 5 && !strings.ContainsAny(q, " .") {
+		results = append(results, models.SearchResult{
+			Symbol:      q,
+			Description: q + " HOLDINGS INC",
+			Type:        "Common Stock",
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Symbol < results[j].Symbol })
+	return results, nil
+}
+
+// Run emits a trade per subscribed symbol every tick until Shutdown
+func (s *SimulatorProvider) Run() {
+	s.mutex.Lock()
+	s.running = true
+	s.mutex.Unlock()
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			for symbol := range s.subscribed {
+				sym := s.advance(symbol, now)
+				trade := models.Trade{
+					Symbol:    symbol,
+					Price:     round2(sym.price),
+					Volume:    float64(1 + sym.tradeRng.Intn(500)),
+					Timestamp: now,
+				}
+				select {
+				case s.trades <- trade:
+				default:
+				}
+			}
+			s.mutex.Unlock()
+
+		case <-s.ctx.Done():
+			s.mutex.Lock()
+			s.running = false
+			s.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// Shutdown stops the trade stream
+func (s *SimulatorProvider) Shutdown() {
+	s.cancel()
+}
+
+// Subscribe starts emitting trades for a symbol
+func (s *SimulatorProvider) Subscribe(symbol string) {
+	s.mutex.Lock()
+	s.subscribed[symbol] = true
+	s.mutex.Unlock()
+}
+
+// Unsubscribe stops emitting trades for a symbol
+func (s *SimulatorProvider) Unsubscribe(symbol string) {
+	s.mutex.Lock()
+	delete(s.subscribed, symbol)
+	s.mutex.Unlock()
+}
+
+// Connected reports whether the trade stream is running
+func (s *SimulatorProvider) Connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running
+}
+
+// Trades returns the channel simulated trades are delivered on
+func (s *SimulatorProvider) Trades() <-chan models.Trade {
+	return s.trades
+}
+
+// advance brings a symbol's price path up to now. Callers must hold mutex.
+func (s *SimulatorProvider) advance(symbol string, now time.Time) *simulatedSymbol {
+	sym, ok := s.symbols[symbol]
+	if !ok {
+		sym = s.newSymbol(symbol)
+		s.symbols[symbol] = sym
+	}
+
+	target := int64(now.Sub(s.start) / s.tick)
+	dt := s.tick.Seconds() / secondsPerTradingYear
+	sigma := sym.volatility * math.Sqrt(dt)
+
+	// The sum of n tick returns is one normal draw with n times the mean
+	// and variance, so the jump is distributed like the walk it replaces
+	if gap := target - sym.ticks; gap > maxWalkedTicks {
+		span := float64(gap) * dt
+		ret := (sym.drift-sym.volatility*sym.volatility/2)*span + sym.volatility*math.Sqrt(span)*sym.rng.NormFloat64()
+		sym.price *= math.Exp(ret)
+		sym.high = math.Max(sym.high, sym.price)
+		sym.low = math.Min(sym.low, sym.price)
+		sym.ticks = target
+	}
+
+	for ; sym.ticks < target; sym.ticks++ {
+		ret := (sym.drift-sym.volatility*sym.volatility/2)*dt + sigma*sym.rng.NormFloat64()
+		sym.price *= math.Exp(ret)
+		sym.high = math.Max(sym.high, sym.price)
+		sym.low = math.Min(sym.low, sym.price)
+	}
+
+	return sym
+}
+
+// newSymbol derives a starting price and volatility from the seed and symbol
+func (s *SimulatorProvider) newSymbol(symbol string) *simulatedSymbol {
+	rng := rand.New(rand.NewSource(s.symbolSeed(symbol)))
+
+	prevClose := 20 + rng.Float64()*480
+	volatility := 0.15 + rng.Float64()*0.45
+	drift := -0.05 + rng.Float64()*0.20
+
+	// Overnight gap between the previous close and today's open
+	open := prevClose * math.Exp(rng.NormFloat64()*volatility/math.Sqrt(252)/2)
+
+	return &simulatedSymbol{
+		rng:        rng,
+		tradeRng:   rand.New(rand.NewSource(s.symbolSeed("trades:" + symbol))),
+		volatility: volatility,
+		drift:      drift,
+		price:      open,
+		open:       open,
+		high:       open,
+		low:        open,
+		prevClose:  prevClose,
+	}
+}
+
+// symbolSeed mixes the provider seed with a key so each stream is independent
+func (s *SimulatorProvider) symbolSeed(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int64(h.Sum64()) ^ s.seed
+}
+
+// resolutionDuration maps Finnhub candle resolutions to durations
+func resolutionDuration(resolution string) (time.Duration, error) {
+	switch resolution {
+	case "1", "5", "15", "30", "60":
+		var minutes int
+		fmt.Sscanf(resolution, "%d", &minutes)
+		return time.Duration(minutes) * time.Minute, nil
+	case "D":
+		return 24 * time.Hour, nil
+	case "W":
+		return 7 * 24 * time.Hour, nil
+	case "M":
+		return 30 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported resolution %q", resolution)
+	}
+}
+
+// tradingYearFraction is the share of a trading year one candle spans. Daily
+// and longer bars count trading days, not calendar time.
+func tradingYearFraction(resolution string, step time.Duration) float64 {
+	switch resolution {
+	case "D":
+		return 1.0 / 252
+	case "W":
+		return 5.0 / 252
+	case "M":
+		return 21.0 / 252
+	default:
+		return step.Seconds() / secondsPerTradingYear
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
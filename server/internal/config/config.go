@@ -2,13 +2,135 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Port           string
-	Environment    string
-	FinnhubAPIKey  string
-	Redis          RedisConfig
+	Port        string
+	Environment string
+	AdminToken  string
+	MarketData  MarketDataConfig
+	Finnhub     FinnhubConfig
+	Redis       RedisConfig
+	Cache       CacheConfig
+	Warm        WarmConfig
+	Hub         HubConfig
+}
+
+type MarketDataConfig struct {
+	// Providers in failover priority order
+	Providers        []string
+	FailoverCooldown time.Duration
+
+	// Simulator settings, used when "simulator" is one of the Providers
+	SimulatorSeed int64
+	SimulatorTick time.Duration
+}
+
+type FinnhubConfig struct {
+	// API keys rotated by headroom; a key that gets a 429 sits out
+	// KeyBenchDuration
+	APIKeys          []string
+	KeyRatePerMinute int
+	KeyBenchDuration time.Duration
+
+	BaseURL       string
+	StreamEnabled bool
+	StreamURL     string
+
+	// Upstream HTTP fixtures: "live", "record" or "replay"
+	FixtureMode string
+	FixtureDir  string
+
+	// Circuit breaker: opens after BreakerFailures consecutive failures,
+	// probes again after BreakerOpenTimeout and closes after
+	// BreakerHalfOpenProbes successful probes
+	BreakerFailures       int
+	BreakerOpenTimeout    time.Duration
+	BreakerHalfOpenProbes int
+}
+
+type CacheConfig struct {
+	// Bounds for the in-memory cache used without Redis; the eviction
+	// policy is "lru" or "lfu" and also applies to L1
+	MemoryMaxEntries int
+	MemoryMaxBytes   int64
+	MemoryEviction   string
+
+	// Where the in-memory cache is saved across restarts; empty disables
+	// snapshots. A zero interval saves only on shutdown.
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+
+	// Keys are prefixed with Namespace and the schema version; values are
+	// written with Codec ("json" or "msgpack") and gzipped above
+	// CompressAbove bytes, zero disabling compression
+	Namespace     string
+	Codec         string
+	CompressAbove int
+
+	// In-process L1 in front of Redis, kept consistent across instances
+	// through invalidations on L1InvalidationChannel
+	L1Enabled             bool
+	L1TTL                 time.Duration
+	L1MaxEntries          int
+	L1InvalidationChannel string
+}
+
+type WarmConfig struct {
+	// Symbols always warmed, plus up to TopSubscribed of the most
+	// subscribed WebSocket symbols
+	Enabled       bool
+	Symbols       []string
+	TopSubscribed int
+
+	// Warm at startup, every Interval (zero disables) and at each DailyAt
+	// time ("HH:MM") in Timezone
+	Interval time.Duration
+	DailyAt  []string
+	Timezone string
+
+	// Upstream requests per minute the warmer may use
+	RatePerMinute int
+	CandleDays    int
+}
+
+type HubConfig struct {
+	// With Redis, hub instances elect one leader to poll upstream and
+	// stream trades, and share its quotes over ClusterChannel
+	ClusterEnabled   bool
+	ClusterChannel   string
+	ClusterLeaderKey string
+	ClusterDemandKey string
+	ClusterLeaseTTL  time.Duration
+
+	// Most channel and symbol pairs one WebSocket client may subscribe to
+	MaxSubscriptions int
+
+	// How often each WebSocket channel is flushed to clients. Order books
+	// and news are also polled this often.
+	QuotesInterval    time.Duration
+	TradesInterval    time.Duration
+	OrderBookInterval time.Duration
+	CandlesInterval   time.Duration
+	NewsInterval      time.Duration
+
+	// Messages kept per channel and symbol for clients resuming after a
+	// reconnect, and how long after the last subscriber leaves
+	ReplaySize      int
+	ReplayRetention time.Duration
+
+	// What happens to a WebSocket client that reads slower than updates
+	// arrive: "conflate", "drop_oldest" or "disconnect" once it has been
+	// behind for DisconnectAfter
+	SlowClientPolicy string
+	DisconnectAfter  time.Duration
+
+	// WebSocket messages larger than CompressAbove bytes are sent with
+	// permessage-deflate to clients that support it, zero disabling it
+	CompressAbove int
 }
 
 type RedisConfig struct {
@@ -20,15 +142,84 @@ type RedisConfig struct {
 
 func Load() *Config {
 	return &Config{
-		Port:          getEnv("PORT", "8080"),
-		Environment:   getEnv("ENVIRONMENT", "development"),
-		FinnhubAPIKey: getEnv("FINNHUB_API_KEY", "d0s5c1pr01qrmnclmaggd0s5c1pr01qrmnclmah0"),
+		Port:        getEnv("PORT", "8080"),
+		Environment: getEnv("ENVIRONMENT", "development"),
+		AdminToken:  getEnv("ADMIN_TOKEN", ""),
+		MarketData: MarketDataConfig{
+			Providers:        getEnvList("MARKET_DATA_PROVIDER", []string{"finnhub"}),
+			FailoverCooldown: getEnvDuration("MARKET_DATA_FAILOVER_COOLDOWN", 30*time.Second),
+			SimulatorSeed:    getEnvInt64("SIMULATOR_SEED", 1),
+			SimulatorTick:    getEnvDuration("SIMULATOR_TICK", time.Second),
+		},
+		Finnhub: FinnhubConfig{
+			// FINNHUB_API_KEYS takes a comma-separated pool; FINNHUB_API_KEY a
+			// single key. There is no default key.
+			APIKeys: getEnvList("FINNHUB_API_KEYS", getEnvList("FINNHUB_API_KEY", nil)),
+			// Finnhub allows 60 requests per minute for free tier
+			KeyRatePerMinute: getEnvInt("FINNHUB_KEY_RATE_PER_MINUTE", 60),
+			KeyBenchDuration: getEnvDuration("FINNHUB_KEY_BENCH_DURATION", time.Minute),
+			BaseURL:          getEnv("FINNHUB_BASE_URL", "https://finnhub.io/api/v1"),
+			StreamEnabled:    getEnvBool("FINNHUB_STREAM_ENABLED", true),
+			StreamURL:        getEnv("FINNHUB_STREAM_URL", "wss://ws.finnhub.io"),
+			FixtureMode:      getEnv("FINNHUB_FIXTURE_MODE", "live"),
+			FixtureDir:       getEnv("FINNHUB_FIXTURE_DIR", "./fixtures/finnhub"),
+
+			BreakerFailures:       getEnvInt("FINNHUB_BREAKER_FAILURES", 5),
+			BreakerOpenTimeout:    getEnvDuration("FINNHUB_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+			BreakerHalfOpenProbes: getEnvInt("FINNHUB_BREAKER_HALF_OPEN_PROBES", 1),
+		},
+		Hub: HubConfig{
+			ClusterEnabled:   getEnvBool("HUB_CLUSTER_ENABLED", true),
+			ClusterChannel:   getEnv("HUB_CLUSTER_CHANNEL", "hub:quotes"),
+			ClusterLeaderKey: getEnv("HUB_CLUSTER_LEADER_KEY", "hub:leader"),
+			ClusterDemandKey: getEnv("HUB_CLUSTER_DEMAND_KEY", "hub:demand"),
+			ClusterLeaseTTL:  getEnvDuration("HUB_CLUSTER_LEASE_TTL", 10*time.Second),
+			MaxSubscriptions: getEnvInt("HUB_MAX_SUBSCRIPTIONS", 50),
+
+			QuotesInterval:    getEnvDuration("HUB_QUOTES_INTERVAL", 100*time.Millisecond),
+			TradesInterval:    getEnvDuration("HUB_TRADES_INTERVAL", 250*time.Millisecond),
+			OrderBookInterval: getEnvDuration("HUB_ORDERBOOK_INTERVAL", time.Second),
+			CandlesInterval:   getEnvDuration("HUB_CANDLES_INTERVAL", time.Second),
+			NewsInterval:      getEnvDuration("HUB_NEWS_INTERVAL", time.Minute),
+
+			ReplaySize:      getEnvInt("HUB_REPLAY_SIZE", 100),
+			ReplayRetention: getEnvDuration("HUB_REPLAY_RETENTION", 30*time.Second),
+
+			SlowClientPolicy: getEnv("HUB_SLOW_CLIENT_POLICY", "conflate"),
+			DisconnectAfter:  getEnvDuration("HUB_SLOW_CLIENT_DISCONNECT_AFTER", 5*time.Second),
+			CompressAbove:    getEnvInt("HUB_COMPRESS_ABOVE", 256),
+		},
 		Redis: RedisConfig{
 			URL:      getEnv("REDIS_URL", "localhost:6379"),
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       0,
 			PoolSize: 10,
 		},
+		Cache: CacheConfig{
+			MemoryMaxEntries:      getEnvInt("CACHE_MEMORY_MAX_ENTRIES", 10000),
+			MemoryMaxBytes:        getEnvInt64("CACHE_MEMORY_MAX_BYTES", 64<<20),
+			MemoryEviction:        getEnv("CACHE_MEMORY_EVICTION", "lru"),
+			SnapshotPath:          getEnv("CACHE_SNAPSHOT_PATH", "./data/cache-snapshot.json"),
+			SnapshotInterval:      getEnvDuration("CACHE_SNAPSHOT_INTERVAL", 5*time.Minute),
+			Namespace:             getEnv("CACHE_NAMESPACE", "equity"),
+			Codec:                 getEnv("CACHE_CODEC", "json"),
+			CompressAbove:         getEnvInt("CACHE_COMPRESS_ABOVE", 0),
+			L1Enabled:             getEnvBool("CACHE_L1_ENABLED", true),
+			L1TTL:                 getEnvDuration("CACHE_L1_TTL", 5*time.Second),
+			L1MaxEntries:          getEnvInt("CACHE_L1_MAX_ENTRIES", 1000),
+			L1InvalidationChannel: getEnv("CACHE_L1_INVALIDATION_CHANNEL", "cache:invalidate"),
+		},
+		Warm: WarmConfig{
+			Enabled:       getEnvBool("CACHE_WARM_ENABLED", true),
+			Symbols:       getEnvList("CACHE_WARM_SYMBOLS", []string{"MSFT", "AAPL", "NVDA", "GOOGL", "AMZN"}),
+			TopSubscribed: getEnvInt("CACHE_WARM_TOP_SUBSCRIBED", 20),
+			Interval:      getEnvDuration("CACHE_WARM_INTERVAL", 0),
+			// Shortly before the 09:30 ET open
+			DailyAt:       getEnvList("CACHE_WARM_DAILY_AT", []string{"09:00"}),
+			Timezone:      getEnv("CACHE_WARM_TIMEZONE", "America/New_York"),
+			RatePerMinute: getEnvInt("CACHE_WARM_RATE_PER_MINUTE", 20),
+			CandleDays:    getEnvInt("CACHE_WARM_CANDLE_DAYS", 30),
+		},
 	}
 }
 
@@ -37,4 +228,45 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvList(key string, defaultValue []string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,134 @@
+package fakefinnhub
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default generated payloads, shaped like Finnhub's responses
+
+func defaultQuote(r *http.Request) interface{} {
+	symbol := r.URL.Query().Get("symbol")
+	price := SymbolPrice(symbol)
+	prevClose := price * 0.99
+
+	return map[string]interface{}{
+		"c":  price,
+		"d":  price - prevClose,
+		"dp": (price - prevClose) / prevClose * 100,
+		"h":  price * 1.01,
+		"l":  price * 0.98,
+		"o":  prevClose * 1.002,
+		"pc": prevClose,
+		"t":  time.Now().Unix(),
+	}
+}
+
+func defaultCandles(r *http.Request) interface{} {
+	query := r.URL.Query()
+	price := SymbolPrice(query.Get("symbol"))
+
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+	if to <= 0 {
+		to = time.Now().Unix()
+	}
+	if from <= 0 || from > to {
+		from = to - 30*86400
+	}
+
+	step := int64(86400)
+	if minutes, err := strconv.Atoi(query.Get("resolution")); err == nil && minutes > 0 {
+		step = int64(minutes) * 60
+	}
+
+	n := (to-from)/step + 1
+	if n > 500 {
+		n = 500
+	}
+
+	closes := make([]float64, n)
+	highs := make([]float64, n)
+	lows := make([]float64, n)
+	opens := make([]float64, n)
+	volumes := make([]int64, n)
+	timestamps := make([]int64, n)
+	for i := int64(0); i < n; i++ {
+		// A gentle zigzag is enough for charts and assertions
+		p := price * (1 + 0.01*float64(i%5-2))
+		opens[i] = p * 0.998
+		closes[i] = p
+		highs[i] = p * 1.01
+		lows[i] = p * 0.99
+		volumes[i] = 100000 + i*1000
+		timestamps[i] = to - (n-1-i)*step
+	}
+
+	return map[string]interface{}{
+		"c": closes,
+		"h": highs,
+		"l": lows,
+		"o": opens,
+		"v": volumes,
+		"t": timestamps,
+		"s": "ok",
+	}
+}
+
+func defaultProfile(r *http.Request) interface{} {
+	symbol := r.URL.Query().Get("symbol")
+
+	return map[string]interface{}{
+		"ticker":               symbol,
+		"name":                 symbol + " Fake Corp",
+		"exchange":             "NASDAQ NMS - GLOBAL MARKET",
+		"finnhubIndustry":      "Technology",
+		"marketCapitalization": SymbolPrice(symbol) * 1000,
+		"shareOutstanding":     1000.0,
+		"logo":                 "",
+		"weburl":               "https://example.com/" + strings.ToLower(symbol),
+	}
+}
+
+func defaultNews(r *http.Request) interface{} {
+	symbol := r.URL.Query().Get("symbol")
+	now := time.Now()
+
+	news := make([]map[string]interface{}, 3)
+	for i := range news {
+		news[i] = map[string]interface{}{
+			"category": "company",
+			"datetime": now.Add(-time.Duration(i) * time.Hour).Unix(),
+			"headline": fmt.Sprintf("%s fake headline %d", symbol, i+1),
+			"id":       i + 1,
+			"image":    "",
+			"related":  symbol,
+			"source":   "Fake Finnhub",
+			"summary":  "Generated by the fake Finnhub server.",
+			"url":      "https://example.com/news/" + strings.ToLower(symbol),
+		}
+	}
+	return news
+}
+
+func defaultSearch(r *http.Request) interface{} {
+	q := strings.ToUpper(r.URL.Query().Get("q"))
+
+	result := []map[string]interface{}{}
+	if q != "" {
+		result = append(result, map[string]interface{}{
+			"description":   q + " FAKE CORP",
+			"displaySymbol": q,
+			"symbol":        q,
+			"type":          "Common Stock",
+		})
+	}
+
+	return map[string]interface{}{
+		"count":  len(result),
+		"result": result,
+	}
+}
@@ -0,0 +1,314 @@
+// Package fakefinnhub is an in-process stand-in for the Finnhub REST API and
+// trade feed. Every endpoint returns generated data by default; tests and
+// local runs can program specific responses, latency, 429s and malformed
+// payloads, either through Go methods or the /_fake control endpoints.
+package fakefinnhub
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Endpoint paths relative to the API prefix, matching Finnhub's
+const (
+	EndpointQuote   = "/quote"
+	EndpointCandle  = "/stock/candle"
+	EndpointProfile = "/stock/profile2"
+	EndpointNews    = "/company-news"
+	EndpointSearch  = "/search"
+)
+
+// APIPrefix is where the REST endpoints are mounted, so a client's base URL
+// is the server URL plus this prefix
+const APIPrefix = "/api/v1"
+
+// StreamPath is where the trade feed WebSocket is served
+const StreamPath = "/ws"
+
+// Response is a programmed reply for an endpoint
+type Response struct {
+	// Status defaults to 200
+	Status int
+
+	// Body is encoded as JSON; Raw, when set, is written verbatim instead
+	Body interface{}
+	Raw  string
+
+	// Latency is added before responding
+	Latency time.Duration
+
+	// Times limits how many requests this response serves; 0 means forever
+	Times int
+}
+
+// Server is a programmable fake Finnhub
+type Server struct {
+	mutex    sync.Mutex
+	programs map[string][]*Response
+	latency  time.Duration
+	requests map[string]int
+
+	stream *tradeFeed
+	mux    *http.ServeMux
+}
+
+// New creates a fake server with default generated responses
+func New() *Server {
+	s := &Server{
+		programs: make(map[string][]*Response),
+		requests: make(map[string]int),
+		stream:   newTradeFeed(),
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc(APIPrefix+EndpointQuote, s.handle(EndpointQuote, "symbol", defaultQuote))
+	s.mux.HandleFunc(APIPrefix+EndpointCandle, s.handle(EndpointCandle, "symbol", defaultCandles))
+	s.mux.HandleFunc(APIPrefix+EndpointProfile, s.handle(EndpointProfile, "symbol", defaultProfile))
+	s.mux.HandleFunc(APIPrefix+EndpointNews, s.handle(EndpointNews, "symbol", defaultNews))
+	s.mux.HandleFunc(APIPrefix+EndpointSearch, s.handle(EndpointSearch, "q", defaultSearch))
+	s.mux.HandleFunc(StreamPath, s.stream.serve)
+
+	s.mux.HandleFunc("/_fake/program", s.handleProgram)
+	s.mux.HandleFunc("/_fake/latency", s.handleLatency)
+	s.mux.HandleFunc("/_fake/trade", s.handleTrade)
+	s.mux.HandleFunc("/_fake/requests", s.handleRequests)
+	s.mux.HandleFunc("/_fake/reset", s.handleReset)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Program queues a response for an endpoint. An empty symbol matches any
+// symbol (or search query); symbol-specific programs take precedence.
+// Programs with Times set are used up in order before falling through.
+func (s *Server) Program(endpoint, symbol string, resp Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := programKey(endpoint, symbol)
+	s.programs[key] = append(s.programs[key], &resp)
+}
+
+// RateLimit makes the next n requests to any endpoint return 429
+func (s *Server) RateLimit(n int) {
+	for _, endpoint := range []string{EndpointQuote, EndpointCandle, EndpointProfile, EndpointNews, EndpointSearch} {
+		s.Program(endpoint, "", Response{
+			Status: http.StatusTooManyRequests,
+			Body:   map[string]string{"error": "API limit reached. Please try again later."},
+			Times:  n,
+		})
+	}
+}
+
+// SetLatency adds a delay to every response
+func (s *Server) SetLatency(d time.Duration) {
+	s.mutex.Lock()
+	s.latency = d
+	s.mutex.Unlock()
+}
+
+// Requests returns how many requests an endpoint has served
+func (s *Server) Requests(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[endpoint]
+}
+
+// Reset clears programs, latency and request counts
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	s.programs = make(map[string][]*Response)
+	s.requests = make(map[string]int)
+	s.latency = 0
+	s.mutex.Unlock()
+}
+
+// PublishTrade sends a trade to every feed connection subscribed to symbol
+func (s *Server) PublishTrade(symbol string, price, volume float64) {
+	s.stream.publish(symbol, price, volume)
+}
+
+// StreamSubscribers returns the symbols feed connections are subscribed to
+func (s *Server) StreamSubscribers() []string {
+	return s.stream.subscribedSymbols()
+}
+
+// handle serves one REST endpoint, preferring programmed responses
+func (s *Server) handle(endpoint, param string, generate func(r *http.Request) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Please use an API key."})
+			return
+		}
+
+		resp, latency := s.next(endpoint, r.URL.Query().Get(param))
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		switch {
+		case resp == nil:
+			writeJSON(w, http.StatusOK, generate(r))
+		case resp.Raw != "":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusOrOK(resp.Status))
+			w.Write([]byte(resp.Raw))
+		default:
+			writeJSON(w, statusOrOK(resp.Status), resp.Body)
+		}
+	}
+}
+
+// next picks the programmed response for a request, if any, and the total
+// latency to apply
+func (s *Server) next(endpoint, symbol string) (*Response, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests[endpoint]++
+
+	for _, key := range []string{programKey(endpoint, symbol), programKey(endpoint, "")} {
+		queue := s.programs[key]
+		if len(queue) == 0 {
+			continue
+		}
+
+		resp := queue[0]
+		if resp.Times > 0 {
+			resp.Times--
+			if resp.Times == 0 {
+				s.programs[key] = queue[1:]
+			}
+		}
+		return resp, s.latency + resp.Latency
+	}
+
+	return nil, s.latency
+}
+
+// programRequest is the body accepted by POST /_fake/program
+type programRequest struct {
+	Endpoint  string      `json:"endpoint"`
+	Symbol    string      `json:"symbol"`
+	Status    int         `json:"status"`
+	Body      interface{} `json:"body"`
+	Raw       string      `json:"raw"`
+	LatencyMs int         `json:"latencyMs"`
+	Times     int         `json:"times"`
+}
+
+func (s *Server) handleProgram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return
+	}
+
+	var req programRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !strings.HasPrefix(req.Endpoint, "/") {
+		req.Endpoint = "/" + req.Endpoint
+	}
+
+	s.Program(req.Endpoint, req.Symbol, Response{
+		Status:  req.Status,
+		Body:    req.Body,
+		Raw:     req.Raw,
+		Latency: time.Duration(req.LatencyMs) * time.Millisecond,
+		Times:   req.Times,
+	})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "programmed"})
+}
+
+func (s *Server) handleLatency(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LatencyMs int `json:"latencyMs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.SetLatency(time.Duration(req.LatencyMs) * time.Millisecond)
+	writeJSON(w, http.StatusOK, map[string]int{"latencyMs": req.LatencyMs})
+}
+
+func (s *Server) handleTrade(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price"`
+		Volume float64 `json:"volume"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Symbol == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "symbol and price are required"})
+		return
+	}
+
+	s.PublishTrade(req.Symbol, req.Price, req.Volume)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "published"})
+}
+
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	counts := make(map[string]int, len(s.requests))
+	for endpoint, count := range s.requests {
+		counts[endpoint] = count
+	}
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, counts)
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset"})
+}
+
+func programKey(endpoint, symbol string) string {
+	return endpoint + "|" + strings.ToUpper(symbol)
+}
+
+func statusOrOK(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("fakefinnhub: encode response: %v", err)
+	}
+}
+
+// SymbolPrice is the stable price generated quotes use for a symbol, between
+// 20 and 520
+func SymbolPrice(symbol string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return 20 + float64(h.Sum32()%50000)/100
+}
+
+// upgrader accepts feed connections from any origin
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
@@ -0,0 +1,123 @@
+package fakefinnhub
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tradeFeed mimics Finnhub's trade WebSocket: clients send subscribe and
+// unsubscribe messages and receive {"type":"trade","data":[...]} batches
+type tradeFeed struct {
+	mutex sync.Mutex
+	conns map[*feedConn]bool
+}
+
+// feedConn is one connected feed client
+type feedConn struct {
+	conn       *websocket.Conn
+	symbols    map[string]bool
+	writeMutex sync.Mutex
+}
+
+func newTradeFeed() *tradeFeed {
+	return &tradeFeed{conns: make(map[*feedConn]bool)}
+}
+
+func (f *tradeFeed) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") == "" {
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	fc := &feedConn{conn: conn, symbols: make(map[string]bool)}
+	f.mutex.Lock()
+	f.conns[fc] = true
+	f.mutex.Unlock()
+
+	defer func() {
+		f.mutex.Lock()
+		delete(f.conns, fc)
+		f.mutex.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var msg struct {
+			Type   string `json:"type"`
+			Symbol string `json:"symbol"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		switch msg.Type {
+		case "subscribe":
+			fc.symbols[msg.Symbol] = true
+		case "unsubscribe":
+			delete(fc.symbols, msg.Symbol)
+		}
+		f.mutex.Unlock()
+	}
+}
+
+func (f *tradeFeed) publish(symbol string, price, volume float64) {
+	msg := map[string]interface{}{
+		"type": "trade",
+		"data": []map[string]interface{}{{
+			"s": symbol,
+			"p": price,
+			"v": volume,
+			"t": time.Now().UnixMilli(),
+		}},
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	f.mutex.Lock()
+	targets := make([]*feedConn, 0, len(f.conns))
+	for fc := range f.conns {
+		if fc.symbols[symbol] {
+			targets = append(targets, fc)
+		}
+	}
+	f.mutex.Unlock()
+
+	for _, fc := range targets {
+		fc.writeMutex.Lock()
+		fc.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		fc.conn.WriteMessage(websocket.TextMessage, data)
+		fc.writeMutex.Unlock()
+	}
+}
+
+func (f *tradeFeed) subscribedSymbols() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	set := make(map[string]bool)
+	for fc := range f.conns {
+		for symbol := range fc.symbols {
+			set[symbol] = true
+		}
+	}
+
+	symbols := make([]string, 0, len(set))
+	for symbol := range set {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"equity-server/internal/cache"
+	"equity-server/internal/clients"
+	"equity-server/internal/hub"
+	"equity-server/internal/models"
+	"equity-server/internal/warmer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache key families that hold per-symbol data
+var symbolCacheFamilies = []string{"quote", "candles", "profile", "news", "orderbook"}
+
+const (
+	defaultCacheKeyLimit = 100
+	maxCacheKeyLimit     = 1000
+)
+
+// AdminHandler handles operator-facing HTTP requests
+type AdminHandler struct {
+	keyPool *clients.KeyPool
+	cache   cache.Cache
+	store   *cache.Store
+	warmer  *warmer.Warmer
+	hub     *hub.Hub
+}
+
+// NewAdminHandler creates a new admin handler. store builds the keys that
+// market data is cached under; warmer is nil when cache warming is
+// disabled.
+func NewAdminHandler(keyPool *clients.KeyPool, cache cache.Cache, store *cache.Store, warmer *warmer.Warmer, hub *hub.Hub) *AdminHandler {
+	return &AdminHandler{
+		keyPool: keyPool,
+		cache:   cache,
+		store:   store,
+		warmer:  warmer,
+		hub:     hub,
+	}
+}
+
+// GetFinnhubKeys handles GET /api/v1/admin/finnhub/keys
+// Returns per-key usage with the keys masked
+func (h *AdminHandler) GetFinnhubKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"keys": h.keyPool.Usage(),
+	})
+}
+
+// GetCacheStats handles GET /api/v1/admin/cache/stats
+// Returns cache hit ratios, overall and per key family
+func (h *AdminHandler) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.store.Stats())
+}
+
+// GetCacheKeys handles GET /api/v1/admin/cache/keys?prefix=equity:v1:quote:&limit=100
+// Lists cached keys starting with prefix
+func (h *AdminHandler) GetCacheKeys(c *gin.Context) {
+	prefix := c.Query("prefix")
+
+	limit := defaultCacheKeyLimit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 || parsed > maxCacheKeyLimit {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:   "invalid_limit",
+				Message: "limit must be between 1 and " + strconv.Itoa(maxCacheKeyLimit),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	keys, err := h.cache.Keys(c.Request.Context(), prefix, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "cache_scan_failed",
+			Message: "Failed to list cache keys: " + err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prefix": prefix,
+		"keys":   keys,
+		"count":  len(keys),
+	})
+}
+
+// FlushCacheSymbol handles DELETE /api/v1/admin/cache/symbols/:symbol
+// Removes every cached entry for a symbol
+func (h *AdminHandler) FlushCacheSymbol(c *gin.Context) {
+	symbol := strings.ToUpper(c.Param("symbol"))
+	if symbol == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "invalid_symbol",
+			Message: "Symbol is required",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	deleted := 0
+	for _, family := range symbolCacheFamilies {
+		// Exact keys like quote:AAPL, then parameterized ones like
+		// candles:AAPL:D:... without touching quote:AAPLX
+		key := h.store.Key(family, symbol)
+		if exists, _ := h.cache.Exists(ctx, key); exists {
+			if err := h.cache.Delete(ctx, key); err != nil {
+				respondCacheFlushError(c, err)
+				return
+			}
+			deleted++
+		}
+
+		n, err := h.cache.DeletePrefix(ctx, key+":")
+		deleted += n
+		if err != nil {
+			respondCacheFlushError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":  symbol,
+		"deleted": deleted,
+	})
+}
+
+// FlushCacheFamily handles DELETE /api/v1/admin/cache/families/:family
+// Removes every cached entry in a key family such as quote or news
+func (h *AdminHandler) FlushCacheFamily(c *gin.Context) {
+	family := strings.ToLower(c.Param("family"))
+	if family == "" || strings.Contains(family, ":") {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "invalid_family",
+			Message: "family must be a key family such as quote or news",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	deleted, err := h.cache.DeletePrefix(c.Request.Context(), h.store.Key(family)+":")
+	if err != nil {
+		respondCacheFlushError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"family":  family,
+		"deleted": deleted,
+	})
+}
+
+// GetCacheWarmStatus handles GET /api/v1/admin/cache/warm
+// Returns the progress and errors of the current or last warm run
+func (h *AdminHandler) GetCacheWarmStatus(c *gin.Context) {
+	if h.warmer == nil {
+		respondWarmDisabled(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.warmer.Status())
+}
+
+// TriggerCacheWarm handles POST /api/v1/admin/cache/warm
+// Starts a warm run now instead of waiting for the schedule
+func (h *AdminHandler) TriggerCacheWarm(c *gin.Context) {
+	if h.warmer == nil {
+		respondWarmDisabled(c)
+		return
+	}
+
+	if !h.warmer.Trigger() {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "warm_in_progress",
+			Message: "A cache warm run is already running or queued",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"queued": true,
+	})
+}
+
+// GetWebSocketClients handles GET /api/v1/admin/ws/clients
+// Returns connected WebSocket clients with how many updates each has had
+// dropped or conflated for falling behind
+func (h *AdminHandler) GetWebSocketClients(c *gin.Context) {
+	stats := h.hub.ClientStats()
+	c.JSON(http.StatusOK, gin.H{
+		"clients": stats,
+		"count":   len(stats),
+	})
+}
+
+func respondWarmDisabled(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Error:   "warm_disabled",
+		Message: "Cache warming is disabled",
+		Code:    http.StatusNotFound,
+	})
+}
+
+func respondCacheFlushError(c *gin.Context, err error) {
+	c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+		Error:   "cache_flush_failed",
+		Message: "Failed to flush cache: " + err.Error(),
+		Code:    http.StatusInternalServerError,
+	})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"equity-server/internal/clients"
+	"equity-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondFetchError maps an upstream error to an HTTP status and error code.
+// Errors that aren't classified keep the handler's own code and a 500.
+func respondFetchError(c *gin.Context, err error, code, message string) {
+	status := http.StatusInternalServerError
+
+	switch clients.ErrorKindOf(err) {
+	case clients.KindRateLimited:
+		status, code = http.StatusTooManyRequests, "rate_limited"
+		if retryAfter := clients.RetryAfterOf(err); retryAfter > 0 {
+			c.Header("Retry-After", retryAfterSeconds(retryAfter))
+		}
+	case clients.KindNotFound:
+		status, code = http.StatusNotFound, "not_found"
+	case clients.KindUpstreamDown, clients.KindCircuitOpen, clients.KindNotConfigured:
+		status, code = http.StatusServiceUnavailable, "upstream_unavailable"
+		if retryAfter := clients.RetryAfterOf(err); retryAfter > 0 {
+			c.Header("Retry-After", retryAfterSeconds(retryAfter))
+		}
+	case clients.KindBadPayload:
+		status, code = http.StatusBadGateway, "bad_upstream_response"
+	}
+
+	c.JSON(status, models.ErrorResponse{
+		Error:   code,
+		Message: message + ": " + err.Error(),
+		Code:    status,
+	})
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, at least one, since
+// Retry-After: 0 would tell clients to retry at once
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
@@ -16,15 +16,15 @@ import (
 
 // StockHandler handles stock-related HTTP requests
 type StockHandler struct {
-	finnhubClient *clients.FinnhubClient
-	cache         cache.Cache
+	provider clients.MarketDataProvider
+	cache    cache.Cache
 }
 
 // NewStockHandler creates a new stock handler
-func NewStockHandler(finnhubClient *clients.FinnhubClient, cache cache.Cache) *StockHandler {
+func NewStockHandler(provider clients.MarketDataProvider, cache cache.Cache) *StockHandler {
 	return &StockHandler{
-		finnhubClient: finnhubClient,
-		cache:         cache,
+		provider: provider,
+		cache:    cache,
 	}
 }
 
@@ -40,13 +40,9 @@ func (h *StockHandler) GetQuote(c *gin.Context) {
 		return
 	}
 
-	quote, err := h.finnhubClient.GetQuote(c.Request.Context(), symbol)
+	quote, err := h.provider.GetQuote(c.Request.Context(), symbol)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "fetch_failed",
-			Message: "Failed to fetch quote: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "fetch_failed", "Failed to fetch quote")
 		return
 	}
 
@@ -96,7 +92,7 @@ func (h *StockHandler) GetBatchQuotes(c *gin.Context) {
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			quote, err := h.finnhubClient.GetQuote(c.Request.Context(), sym)
+			quote, err := h.provider.GetQuote(c.Request.Context(), sym)
 			if err != nil {
 				errorChan <- err
 				return
@@ -115,11 +111,7 @@ func (h *StockHandler) GetBatchQuotes(c *gin.Context) {
 	select {
 	case err := <-errorChan:
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "fetch_failed",
-				Message: "Failed to fetch some quotes: " + err.Error(),
-				Code:    http.StatusInternalServerError,
-			})
+			respondFetchError(c, err, "fetch_failed", "Failed to fetch some quotes")
 			return
 		}
 	default:
@@ -150,7 +142,7 @@ func (h *StockHandler) GetCandles(c *gin.Context) {
 			return
 		}
 	} else {
-		from = time.Now().AddDate(0, -1, 0).Unix() // Default to 1 month ago
+		from = time.Now().AddDate(0, 0, -clients.DefaultCandleDays).Unix()
 	}
 
 	if toStr != "" {
@@ -167,13 +159,9 @@ func (h *StockHandler) GetCandles(c *gin.Context) {
 		to = time.Now().Unix()
 	}
 
-	candles, err := h.finnhubClient.GetCandles(c.Request.Context(), symbol, resolution, from, to)
+	candles, err := h.provider.GetCandles(c.Request.Context(), symbol, resolution, from, to)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "fetch_failed",
-			Message: "Failed to fetch candles: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "fetch_failed", "Failed to fetch candles")
 		return
 	}
 
@@ -193,13 +181,9 @@ func (h *StockHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	profile, err := h.finnhubClient.GetProfile(c.Request.Context(), symbol)
+	profile, err := h.provider.GetProfile(c.Request.Context(), symbol)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "fetch_failed",
-			Message: "Failed to fetch profile: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "fetch_failed", "Failed to fetch profile")
 		return
 	}
 
@@ -213,13 +197,9 @@ func (h *StockHandler) GetNews(c *gin.Context) {
 	from := c.DefaultQuery("from", time.Now().AddDate(0, 0, -7).Format("2006-01-02"))
 	to := c.DefaultQuery("to", time.Now().Format("2006-01-02"))
 
-	news, err := h.finnhubClient.GetNews(c.Request.Context(), symbol, from, to)
+	news, err := h.provider.GetNews(c.Request.Context(), symbol, from, to)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "fetch_failed",
-			Message: "Failed to fetch news: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "fetch_failed", "Failed to fetch news")
 		return
 	}
 
@@ -239,13 +219,9 @@ func (h *StockHandler) GetOrderBook(c *gin.Context) {
 		return
 	}
 
-	orderBook, err := h.finnhubClient.GetOrderBook(c.Request.Context(), symbol)
+	orderBook, err := h.provider.GetOrderBook(c.Request.Context(), symbol)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "fetch_failed",
-			Message: "Failed to fetch order book: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "fetch_failed", "Failed to fetch order book")
 		return
 	}
 
@@ -265,13 +241,9 @@ func (h *StockHandler) SearchStocks(c *gin.Context) {
 		return
 	}
 
-	results, err := h.finnhubClient.SearchSymbols(c.Request.Context(), query)
+	results, err := h.provider.SearchSymbols(c.Request.Context(), query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "search_failed",
-			Message: "Failed to search stocks: " + err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondFetchError(c, err, "search_failed", "Failed to search stocks")
 		return
 	}
 
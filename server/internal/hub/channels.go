@@ -0,0 +1,271 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// Channel is a kind of data clients can subscribe to per symbol
+type Channel string
+
+// Channels clients can subscribe to
+const (
+	ChannelQuotes    Channel = "quotes"
+	ChannelTrades    Channel = "trades"
+	ChannelOrderBook Channel = "orderbook"
+	ChannelCandles   Channel = "candles"
+	ChannelNews      Channel = "news"
+)
+
+// marketChannels are fed by upstream quotes and trades, so a subscription
+// to any of them makes the hub poll or stream the symbol
+var marketChannels = []Channel{ChannelQuotes, ChannelTrades, ChannelCandles}
+
+const (
+	// Trades and bars kept per symbol between flushes
+	maxBufferedTrades = 200
+	maxBufferedBars   = 10
+)
+
+// channelSpec describes how a channel's updates are delivered
+type channelSpec struct {
+	// Type of the WebSocket messages carrying the channel's updates
+	messageType string
+
+	// Default time between flushes; updates in between are merged
+	interval time.Duration
+
+	// Default least time between updates to one subscriber, which a
+	// subscribe request can change; zero sends every flush
+	subscriberInterval time.Duration
+
+	// merge folds an update into whatever is pending for the symbol,
+	// which is nil if nothing is. It never modifies update, so merging
+	// into nil copies it.
+	merge func(pending, update interface{}) interface{}
+
+	// conflate folds an update into one still queued for a client that
+	// fell behind, or is nil if every update must be delivered
+	conflate func(queued, update interface{}) interface{}
+}
+
+var channelSpecs = map[Channel]channelSpec{
+	ChannelQuotes: {
+		messageType:        "quote",
+		interval:           100 * time.Millisecond,
+		subscriberInterval: 500 * time.Millisecond,
+		merge:              mergeLatest,
+		conflate:           conflateQuotes,
+	},
+	ChannelTrades: {
+		messageType: "trades",
+		interval:    250 * time.Millisecond,
+		merge:       mergeTrades,
+	},
+	ChannelOrderBook: {
+		messageType: "orderbook",
+		interval:    time.Second,
+		merge:       mergeOrderBook,
+	},
+	ChannelCandles: {
+		messageType: "candles",
+		interval:    time.Second,
+		merge:       mergeBars,
+	},
+	ChannelNews: {
+		messageType: "news",
+		interval:    time.Minute,
+		merge:       mergeNews,
+	},
+}
+
+// ParseChannel looks up a channel by name
+func ParseChannel(name string) (Channel, bool) {
+	channel := Channel(name)
+	_, ok := channelSpecs[channel]
+	return channel, ok
+}
+
+// channelBuffer throttles one channel, holding merged updates per symbol
+// until the next flush
+type channelBuffer struct {
+	channel  Channel
+	spec     channelSpec
+	interval time.Duration
+
+	pending map[string]interface{}
+	rate    float64
+	mutex   sync.Mutex
+}
+
+func newChannelBuffers() map[Channel]*channelBuffer {
+	buffers := make(map[Channel]*channelBuffer, len(channelSpecs))
+	for channel, spec := range channelSpecs {
+		buffers[channel] = &channelBuffer{
+			channel:  channel,
+			spec:     spec,
+			interval: spec.interval,
+			pending:  make(map[string]interface{}),
+		}
+	}
+	return buffers
+}
+
+// SetChannelInterval changes how often a channel is flushed to clients,
+// which for order books and news is also how often they are polled. It
+// must be called before Run.
+func (h *Hub) SetChannelInterval(channel Channel, interval time.Duration) {
+	if buffer, ok := h.channels[channel]; ok && interval > 0 {
+		buffer.interval = interval
+	}
+}
+
+// publish queues an update for a channel's subscribers to a symbol
+func (h *Hub) publish(channel Channel, symbol string, update interface{}) {
+	buffer := h.channels[channel]
+	buffer.mutex.Lock()
+	buffer.pending[symbol] = buffer.spec.merge(buffer.pending[symbol], update)
+	buffer.mutex.Unlock()
+}
+
+// flushChannel sends a channel's pending updates on its interval
+func (h *Hub) flushChannel(buffer *channelBuffer) {
+	ticker := time.NewTicker(buffer.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.flushBuffer(buffer)
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) flushBuffer(buffer *channelBuffer) {
+	buffer.mutex.Lock()
+	updates := buffer.pending
+	// Clear buffer
+	buffer.pending = make(map[string]interface{})
+	buffer.mutex.Unlock()
+
+	// Send updates to subscribers. Topics whose subscribers just left still
+	// number and keep updates for clients that resume.
+	for symbol, update := range updates {
+		t := topic{buffer.channel, symbol}
+		subscribers := h.subscriptions.subscribers(t)
+		if len(subscribers) == 0 && !h.hasTopic(t) {
+			continue
+		}
+		h.sendUpdate(t, update, subscribers)
+	}
+
+	// Update metrics
+	buffer.mutex.Lock()
+	buffer.rate = float64(len(updates)) / buffer.interval.Seconds()
+	buffer.mutex.Unlock()
+
+	var rate float64
+	for _, b := range h.channels {
+		b.mutex.Lock()
+		rate += b.rate
+		b.mutex.Unlock()
+	}
+
+	h.metrics.mutex.Lock()
+	h.metrics.MessagesPerSecond = rate
+	h.metrics.mutex.Unlock()
+}
+
+// sendUpdate numbers an update on t, keeps it for replay and sends it to
+// the subscribers that have been synced. Updates to a topic idle past the
+// replay retention are dropped.
+func (h *Hub) sendUpdate(t topic, update interface{}, subscribers []*Client) {
+	state := h.lockTopic(t)
+	defer state.mutex.Unlock()
+
+	if state.expire(time.Now(), h.replayRetention) {
+		return
+	}
+
+	msg := state.record(func(seq uint64) *wireMessage {
+		return h.channelMessage(t, update, seq, false)
+	}, h.replaySize)
+
+	// Clients that fall behind are handled by the slow client policy.
+	// Clients last sent the same quote share one delta message.
+	deltas := make(quoteDeltas)
+	for _, client := range subscribers {
+		client.sendUpdate(t, msg, deltas)
+	}
+}
+
+// channelMessage wraps an update or snapshot on t for sending
+func (h *Hub) channelMessage(t topic, update interface{}, seq uint64, snapshot bool) *wireMessage {
+	return newWireMessage(models.WebSocketMessage{
+		Type:      channelSpecs[t.channel].messageType,
+		Channel:   string(t.channel),
+		Symbol:    t.symbol,
+		Seq:       seq,
+		Snapshot:  snapshot,
+		Data:      update,
+		Timestamp: time.Now(),
+	})
+}
+
+// mergeLatest keeps only the newest update
+func mergeLatest(pending, update interface{}) interface{} {
+	return update
+}
+
+// mergeTrades batches trades, keeping the most recent if a client would
+// otherwise get more than maxBufferedTrades at once
+func mergeTrades(pending, update interface{}) interface{} {
+	trades, _ := pending.([]models.Trade)
+	trades = append(trades, update.([]models.Trade)...)
+	if len(trades) > maxBufferedTrades {
+		trades = trades[len(trades)-maxBufferedTrades:]
+	}
+	return trades
+}
+
+// mergeBars batches bars, replacing the last one while its minute is
+// still being built
+func mergeBars(pending, update interface{}) interface{} {
+	bars, _ := pending.([]models.Bar)
+	for _, bar := range update.([]models.Bar) {
+		if n := len(bars); n > 0 && bars[n-1].Time == bar.Time {
+			bars[n-1] = bar
+			continue
+		}
+		bars = append(bars, bar)
+	}
+	if len(bars) > maxBufferedBars {
+		bars = bars[len(bars)-maxBufferedBars:]
+	}
+	return bars
+}
+
+// mergeNews batches newly seen articles
+func mergeNews(pending, update interface{}) interface{} {
+	items, _ := pending.([]models.NewsItem)
+	return append(items, update.([]models.NewsItem)...)
+}
+
+// mergeOrderBook applies a diff on top of whatever is pending. A snapshot
+// replaces anything pending.
+func mergeOrderBook(pending, update interface{}) interface{} {
+	next := update.(models.OrderBookUpdate)
+	prev, ok := pending.(models.OrderBookUpdate)
+	if !ok || next.Kind == orderBookSnapshot {
+		return next
+	}
+
+	snapshot := prev.Kind == orderBookSnapshot
+	prev.Bids = applyLevels(prev.Bids, next.Bids, snapshot, true)
+	prev.Asks = applyLevels(prev.Asks, next.Asks, snapshot, false)
+	return prev
+}
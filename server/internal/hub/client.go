@@ -1,8 +1,8 @@
 package hub
 
 import (
-	"encoding/json"
 	"log"
+	"net/http"
 	"time"
 
 	"equity-server/internal/models"
@@ -20,10 +20,91 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, enough for a full batch of
+	// symbols
+	maxMessageSize = 4096
 )
 
+// trySend queues a reply, which is never dropped. It returns false if the
+// client is closed.
+func (c *Client) trySend(msg *wireMessage) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.enqueue(outbound{msg: msg})
+}
+
+// sendSnapshot queues the snapshot that starts t. It returns false if the
+// client is closed.
+func (c *Client) sendSnapshot(t topic, msg *wireMessage) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sentFull(t, msg)
+	return c.enqueue(outbound{msg: msg, topic: t})
+}
+
+// sendMissed queues an update on t replayed to a client that is resuming.
+// It returns false if the client is closed.
+func (c *Client) sendMissed(t topic, msg *wireMessage) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sentFull(t, msg)
+	return c.enqueue(outbound{msg: msg, topic: t, update: true})
+}
+
+// sentFull records a full quote sent on t as the base for the next delta.
+// The caller holds c.mutex.
+func (c *Client) sentFull(t topic, msg *wireMessage) {
+	quote, ok := msg.message.Data.(*models.Quote)
+	if sub := c.subscriptions[t]; sub != nil && ok && quote != nil {
+		sub.quote = quote
+	}
+}
+
+// markSynced starts sending updates on t, if the client is still
+// subscribed to it
+func (c *Client) markSynced(t topic) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if sub := c.subscriptions[t]; sub != nil {
+		sub.synced = true
+		sub.lastSent = time.Now()
+	}
+}
+
+// close stops the client, which makes writePump close the connection. It
+// is safe to call more than once.
+func (c *Client) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closeLocked()
+}
+
+// closeLocked is close for callers holding c.mutex
+func (c *Client) closeLocked() {
+	if !c.closed {
+		c.closed = true
+		c.queue = nil
+		close(c.done)
+	}
+}
+
+// subscribedTopics returns a snapshot of the client's subscriptions
+func (c *Client) subscribedTopics() []topic {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	topics := make([]topic, 0, len(c.subscriptions))
+	for t := range c.subscriptions {
+		topics = append(topics, t)
+	}
+	return topics
+}
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
@@ -39,7 +120,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		frameType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -53,6 +134,7 @@ func (c *Client) readPump() {
 			c.messageCount++
 			if c.messageCount > 10 {
 				log.Printf("Rate limit exceeded for client %s", c.id)
+				c.replyError(nil, errRateLimited, "Too many messages, slow down", http.StatusTooManyRequests)
 				continue
 			}
 		} else {
@@ -61,7 +143,7 @@ func (c *Client) readPump() {
 		}
 
 		// Process message
-		c.processMessage(message)
+		c.processMessage(frameType, message)
 	}
 }
 
@@ -75,29 +157,19 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.ready:
+			// Each message goes in its own frame
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			for _, message := range c.takeQueued() {
+				if err := c.write(message); err != nil {
+					return
+				}
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
-				return
-			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
@@ -108,40 +180,16 @@ func (c *Client) writePump() {
 	}
 }
 
-// processMessage handles incoming messages from the client
-func (c *Client) processMessage(message []byte) {
-	var msg models.SubscriptionMessage
-	if err := json.Unmarshal(message, &msg); err != nil {
-		log.Printf("Error parsing message from client %s: %v", c.id, err)
-		return
+// write sends one message in the client's wire format, compressed if it is
+// big enough to be worth it. Messages that can't be encoded are skipped.
+func (c *Client) write(message *wireMessage) error {
+	data, err := message.encode(c.format)
+	if err != nil {
+		log.Printf("Error encoding %s message as %s for client %s: %v", message.message.Type, c.format, c.id, err)
+		return nil
 	}
 
-	switch msg.Type {
-	case "subscribe":
-		if msg.Symbol != "" {
-			c.hub.subscribeClientToSymbol(c, msg.Symbol)
-		}
-
-	case "unsubscribe":
-		if msg.Symbol != "" {
-			c.hub.unsubscribeClientFromSymbol(c, msg.Symbol)
-		}
-
-	case "ping":
-		// Send pong response
-		pongMsg := models.WebSocketMessage{
-			Type:      "pong",
-			Timestamp: time.Now(),
-		}
-		if data, err := json.Marshal(pongMsg); err == nil {
-			select {
-			case c.send <- data:
-			default:
-				// Channel is full, client might be slow
-			}
-		}
-
-	default:
-		log.Printf("Unknown message type '%s' from client %s", msg.Type, c.id)
-	}
+	compressAbove := c.hub.compressAbove
+	c.conn.EnableWriteCompression(compressAbove > 0 && len(data) > compressAbove)
+	return c.conn.WriteMessage(wireFormats[c.format].frameType, data)
 }
\ No newline at end of file
@@ -0,0 +1,428 @@
+package hub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ClusterOptions names the Redis keys hub instances coordinate through
+type ClusterOptions struct {
+	// Channel carries upstream updates from the leader to every instance
+	Channel string
+
+	// LeaderKey holds the lease of the instance that polls upstream
+	LeaderKey string
+
+	// DemandKey is a sorted set of every symbol subscribed on any
+	// instance, scored by when an instance last reported it. Order book
+	// and news demand go in sets of their own named after it, such as
+	// hub:demand:news.
+	DemandKey string
+
+	// LeaseTTL bounds how long a dead leader keeps the lease and how long
+	// a symbol stays in demand after its last report
+	LeaseTTL time.Duration
+}
+
+// clusterRole is what an instance does for upstream data
+type clusterRole int
+
+const (
+	// Follower instances only fan out quotes published by the leader
+	roleFollower clusterRole = iota
+
+	// The leader polls upstream for every symbol in demand and publishes
+	// the results
+	roleLeader
+
+	// Without Redis an instance falls back to polling for its own clients
+	roleStandalone
+)
+
+// clusterFeed is upstream data the leader fetches for the cluster. Each
+// has its own demand, so a symbol only followed for news isn't polled for
+// quotes.
+type clusterFeed string
+
+const (
+	// Quotes and trades, for the market channels
+	feedMarket    clusterFeed = "market"
+	feedOrderBook clusterFeed = "orderbook"
+	feedNews      clusterFeed = "news"
+)
+
+var clusterFeeds = []clusterFeed{feedMarket, feedOrderBook, feedNews}
+
+func (r clusterRole) String() string {
+	switch r {
+	case roleLeader:
+		return "leader"
+	case roleStandalone:
+		return "standalone"
+	default:
+		return "follower"
+	}
+}
+
+// campaignScript takes the lease when it is free or renews it when this
+// instance already holds it
+var campaignScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// resignScript releases the lease only if this instance holds it
+var resignScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Cluster lets several hub instances share one upstream poller. Every
+// instance reports the symbols its clients subscribe to; the instance
+// holding the leader lease polls upstream for all of them and publishes
+// each quote, trade, order book and news poll over Redis pub/sub, and
+// every instance fans them out to its own clients. Upstream load stays the
+// same however many instances run.
+type Cluster struct {
+	client     *redis.Client
+	options    ClusterOptions
+	instanceID string
+
+	// Symbols this instance fetches upstream for per feed, by role
+	role  clusterRole
+	owned map[clusterFeed][]string
+	mutex sync.RWMutex
+}
+
+// NewCluster creates a cluster member using client for coordination
+func NewCluster(client *redis.Client, options ClusterOptions) *Cluster {
+	if options.LeaseTTL <= 0 {
+		options.LeaseTTL = 10 * time.Second
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &Cluster{
+		client:     client,
+		options:    options,
+		instanceID: hex.EncodeToString(id),
+	}
+}
+
+// leading reports whether this instance publishes quotes for the cluster
+func (c *Cluster) leading() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.role == roleLeader
+}
+
+// ownedSymbols returns the symbols this instance fetches feed for
+func (c *Cluster) ownedSymbols(feed clusterFeed) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string(nil), c.owned[feed]...)
+}
+
+// sync reports this instance's subscribed symbols per feed, campaigns for
+// the lease and works out which symbols this instance now fetches. It
+// returns the new role and owned symbols.
+func (c *Cluster) sync(ctx context.Context, local map[clusterFeed][]string) (clusterRole, map[clusterFeed][]string) {
+	role, owned, err := c.elect(ctx, local)
+
+	c.mutex.Lock()
+	if role != c.role {
+		if err != nil {
+			log.Printf("Hub cluster: instance %s is now %s: %v", c.instanceID, role, err)
+		} else {
+			log.Printf("Hub cluster: instance %s is now %s", c.instanceID, role)
+		}
+	}
+	c.role = role
+	c.owned = owned
+	c.mutex.Unlock()
+
+	return role, owned
+}
+
+// elect decides this instance's role. When Redis can't be reached it polls
+// for its own clients, and a leader that can't read the demand set polls
+// for its own clients until it can.
+func (c *Cluster) elect(ctx context.Context, local map[clusterFeed][]string) (clusterRole, map[clusterFeed][]string, error) {
+	for _, feed := range clusterFeeds {
+		if err := c.advertise(ctx, feed, local[feed]); err != nil {
+			return roleStandalone, local, err
+		}
+	}
+
+	won, err := campaignScript.Run(ctx, c.client, []string{c.options.LeaderKey},
+		c.instanceID, c.options.LeaseTTL.Milliseconds()).Int()
+	if err != nil {
+		return roleStandalone, local, err
+	}
+	if won == 0 {
+		return roleFollower, nil, nil
+	}
+
+	demand := make(map[clusterFeed][]string, len(clusterFeeds))
+	for _, feed := range clusterFeeds {
+		symbols, err := c.demand(ctx, feed)
+		if err != nil {
+			return roleLeader, local, err
+		}
+		demand[feed] = symbols
+	}
+	return roleLeader, demand, nil
+}
+
+// demandKey names the sorted set holding the demand for feed
+func (c *Cluster) demandKey(feed clusterFeed) string {
+	if feed == feedMarket {
+		return c.options.DemandKey
+	}
+	return c.options.DemandKey + ":" + string(feed)
+}
+
+// advertise marks symbols as in demand for feed as of now
+func (c *Cluster) advertise(ctx context.Context, feed clusterFeed, symbols []string) error {
+	if len(symbols) == 0 {
+		return c.client.Ping(ctx).Err()
+	}
+
+	now := float64(time.Now().UnixMilli())
+	members := make([]*redis.Z, 0, len(symbols))
+	for _, symbol := range symbols {
+		members = append(members, &redis.Z{Score: now, Member: symbol})
+	}
+	return c.client.ZAdd(ctx, c.demandKey(feed), members...).Err()
+}
+
+// demand drops symbols nobody has reported for feed within the lease TTL
+// and returns the rest
+func (c *Cluster) demand(ctx context.Context, feed clusterFeed) ([]string, error) {
+	cutoff := time.Now().Add(-c.options.LeaseTTL).UnixMilli()
+	key := c.demandKey(feed)
+
+	pipe := c.client.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	symbols := pipe.ZRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return symbols.Val(), nil
+}
+
+// resign hands the lease back so another instance can take over at once
+func (c *Cluster) resign(ctx context.Context) {
+	resignScript.Run(ctx, c.client, []string{c.options.LeaderKey}, c.instanceID)
+}
+
+// clusterEvent carries one upstream update between instances
+type clusterEvent struct {
+	Quote *models.Quote `json:"quote,omitempty"`
+	Trade *models.Trade `json:"trade,omitempty"`
+
+	// A polled order book and when it was fetched, in Unix nanoseconds,
+	// or polled news, for Symbol
+	Symbol    string            `json:"symbol,omitempty"`
+	OrderBook *models.OrderBook `json:"orderBook,omitempty"`
+	FetchedAt int64             `json:"fetchedAt,omitempty"`
+	News      []models.NewsItem `json:"news,omitempty"`
+}
+
+// valid reports whether an event carries an update for a named symbol
+func (e clusterEvent) valid() bool {
+	switch {
+	case e.Quote != nil:
+		return e.Quote.Symbol != ""
+	case e.Trade != nil:
+		return e.Trade.Symbol != ""
+	default:
+		return e.Symbol != "" && (e.OrderBook != nil || e.News != nil)
+	}
+}
+
+// publish sends an update to every instance
+func (c *Cluster) publish(ctx context.Context, event clusterEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.options.Channel, data).Err()
+}
+
+// subscribe returns the updates published to the cluster until ctx is done
+func (c *Cluster) subscribe(ctx context.Context) <-chan clusterEvent {
+	pubsub := c.client.Subscribe(ctx, c.options.Channel)
+	events := make(chan clusterEvent, 256)
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event clusterEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				if !event.valid() {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// runCluster keeps this instance's symbols reported, campaigns for the
+// lease, points the trade feed at the symbols this instance now fetches and
+// fans out the updates published by the leader
+func (h *Hub) runCluster() {
+	events := h.cluster.subscribe(h.ctx)
+
+	ticker := time.NewTicker(h.cluster.options.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	streaming := make(map[string]bool)
+	streamStarted := false
+
+	refresh := func() {
+		role, owned := h.cluster.sync(h.ctx, map[clusterFeed][]string{
+			feedMarket:    h.marketSymbols(),
+			feedOrderBook: h.subscriptions.symbols(ChannelOrderBook),
+			feedNews:      h.subscriptions.symbols(ChannelNews),
+		})
+		if h.stream == nil {
+			return
+		}
+
+		// Only connect upstream once this instance has something to fetch.
+		// A demoted leader owns nothing, so unsubscribing everything below
+		// closes its upstream connection until it leads again.
+		if !streamStarted && role != roleFollower {
+			go h.stream.Run()
+			streamStarted = true
+		}
+
+		wanted := make(map[string]bool, len(owned[feedMarket]))
+		for _, symbol := range owned[feedMarket] {
+			wanted[symbol] = true
+			if !streaming[symbol] {
+				h.stream.Subscribe(symbol)
+			}
+		}
+		for symbol := range streaming {
+			if !wanted[symbol] {
+				h.stream.Unsubscribe(symbol)
+			}
+		}
+		streaming = wanted
+	}
+
+	refresh()
+	for {
+		select {
+		case event := <-events:
+			if event.Quote != nil {
+				h.handleQuote(event.Quote)
+			}
+			if event.Trade != nil {
+				h.handleTrade(*event.Trade)
+			}
+			if event.OrderBook != nil {
+				h.updateOrderBook(event.Symbol, event.OrderBook, time.Unix(0, event.FetchedAt))
+			}
+			if event.News != nil {
+				h.updateNews(event.Symbol, event.News)
+			}
+
+		case <-ticker.C:
+			refresh()
+
+		case <-h.ctx.Done():
+			// Hand over right away rather than when the lease runs out
+			if h.cluster.leading() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				h.cluster.resign(ctx)
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// emitQuote delivers a polled quote. The leader publishes it so every
+// instance's clients get it; otherwise, or if publishing fails, it goes to
+// this instance's clients only.
+func (h *Hub) emitQuote(quote *models.Quote) {
+	if h.publishToCluster(quote.Symbol, clusterEvent{Quote: quote}) {
+		return
+	}
+	h.handleQuote(quote)
+}
+
+// emitTrade delivers a streamed trade the same way
+func (h *Hub) emitTrade(trade models.Trade) {
+	if h.publishToCluster(trade.Symbol, clusterEvent{Trade: &trade}) {
+		return
+	}
+	h.handleTrade(trade)
+}
+
+// emitOrderBook delivers a polled order book the same way
+func (h *Hub) emitOrderBook(symbol string, book *models.OrderBook, fetchedAt time.Time) {
+	if h.publishToCluster(symbol, clusterEvent{Symbol: symbol, OrderBook: book, FetchedAt: fetchedAt.UnixNano()}) {
+		return
+	}
+	h.updateOrderBook(symbol, book, fetchedAt)
+}
+
+// emitNews delivers polled news the same way
+func (h *Hub) emitNews(symbol string, items []models.NewsItem) {
+	if h.publishToCluster(symbol, clusterEvent{Symbol: symbol, News: items}) {
+		return
+	}
+	h.updateNews(symbol, items)
+}
+
+// publishToCluster publishes an event if this instance leads, reporting
+// whether it was published
+func (h *Hub) publishToCluster(symbol string, event clusterEvent) bool {
+	if h.cluster == nil || !h.cluster.leading() {
+		return false
+	}
+	if err := h.cluster.publish(h.ctx, event); err != nil {
+		log.Printf("Hub cluster publish failed for %s: %v", symbol, err)
+		return false
+	}
+	return true
+}
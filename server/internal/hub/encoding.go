@@ -0,0 +1,148 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"equity-server/internal/cache"
+	"equity-server/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// wireFormat is how messages are encoded on a connection
+type wireFormat int
+
+// Wire formats clients can negotiate
+const (
+	formatJSON wireFormat = iota
+	formatMsgpack
+	formatProtobuf
+
+	formatCount
+)
+
+// Messages larger than this are compressed when no threshold is configured
+const defaultCompressAbove = 256
+
+var wireFormats = [formatCount]struct {
+	name string
+
+	// JSON goes in text frames, everything else in binary frames
+	frameType int
+
+	marshal   func(msg models.WebSocketMessage) ([]byte, error)
+	unmarshal func(data []byte, msg *models.SubscriptionMessage) error
+}{
+	formatJSON: {
+		name:      "json",
+		frameType: websocket.TextMessage,
+		marshal:   marshalJSON,
+		unmarshal: unmarshalJSONRequest,
+	},
+	formatMsgpack: {
+		name:      "msgpack",
+		frameType: websocket.BinaryMessage,
+		marshal:   marshalMsgpack,
+		unmarshal: unmarshalMsgpackRequest,
+	},
+	formatProtobuf: {
+		name:      "protobuf",
+		frameType: websocket.BinaryMessage,
+		marshal:   marshalProtobuf,
+		unmarshal: unmarshalProtobufRequest,
+	},
+}
+
+func marshalJSON(msg models.WebSocketMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func unmarshalJSONRequest(data []byte, msg *models.SubscriptionMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+// MessagePack keeps the JSON field names, so clients decode the same shapes
+func marshalMsgpack(msg models.WebSocketMessage) ([]byte, error) {
+	return cache.MessagePack.Marshal(msg)
+}
+
+func unmarshalMsgpackRequest(data []byte, msg *models.SubscriptionMessage) error {
+	return cache.MessagePack.Unmarshal(data, msg)
+}
+
+func (f wireFormat) String() string {
+	return wireFormats[f].name
+}
+
+// subprotocol names f at the current schema version, like equity.v1.json.
+// A new ProtocolVersion gets new subprotocol names, so clients never get a
+// schema they didn't ask for.
+func (f wireFormat) subprotocol() string {
+	return fmt.Sprintf("equity.v%d.%s", ProtocolVersion, f)
+}
+
+// subprotocols lists every format's subprotocol, most compact first, which
+// is the order the server prefers them in when a client offers several
+func subprotocols() []string {
+	return []string{
+		formatProtobuf.subprotocol(),
+		formatMsgpack.subprotocol(),
+		formatJSON.subprotocol(),
+	}
+}
+
+// parseWireFormat looks up a format by name or subprotocol
+func parseWireFormat(name string) (wireFormat, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for f := wireFormat(0); f < formatCount; f++ {
+		if name == f.String() || name == f.subprotocol() {
+			return f, true
+		}
+	}
+	return formatJSON, false
+}
+
+// SetCompression sets the size in bytes above which messages are
+// compressed with permessage-deflate, for clients that support it. Zero
+// disables compression. It must be called before Run.
+func (h *Hub) SetCompression(compressAbove int) {
+	if compressAbove >= 0 {
+		h.compressAbove = compressAbove
+	}
+}
+
+// wireMessage is a message to one or more clients. It is encoded lazily,
+// at most once per format, so a broadcast costs one encoding for each
+// format in use rather than one per client.
+type wireMessage struct {
+	message models.WebSocketMessage
+
+	once [formatCount]sync.Once
+	data [formatCount][]byte
+	err  [formatCount]error
+}
+
+func newWireMessage(msg models.WebSocketMessage) *wireMessage {
+	return &wireMessage{message: msg}
+}
+
+// encode returns the message in format f
+func (m *wireMessage) encode(f wireFormat) ([]byte, error) {
+	m.once[f].Do(func() {
+		m.data[f], m.err[f] = wireFormats[f].marshal(m.message)
+	})
+	return m.data[f], m.err[f]
+}
+
+// decodeRequest reads a request in the client's format. Text frames are
+// always JSON, so any client can fall back to it.
+func (c *Client) decodeRequest(frameType int, data []byte, msg *models.SubscriptionMessage) (wireFormat, error) {
+	format := c.format
+	if frameType == websocket.TextMessage {
+		format = formatJSON
+	}
+	return format, wireFormats[format].unmarshal(data, msg)
+}
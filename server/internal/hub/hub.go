@@ -3,6 +3,7 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -21,11 +22,12 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
 	},
+	Subprotocols: subprotocols(),
 }
 
 // Hub manages WebSocket connections and data distribution
 type Hub struct {
-	// Registered clients
+	// Registered clients, only touched by Run
 	clients map[*Client]bool
 
 	// Register client requests
@@ -34,18 +36,69 @@ type Hub struct {
 	// Unregister client requests
 	unregister chan *Client
 
-	// Symbol subscriptions - maps symbol to set of clients
-	subscriptions map[string]map[*Client]bool
+	// Requests for the registered clients
+	listClients chan chan []*Client
 
-	// Finnhub client for data fetching
-	finnhubClient *clients.FinnhubClient
+	// Subscriptions - maps each channel of a symbol to set of clients
+	subscriptions *registry
+
+	// Most symbols one client may subscribe to
+	maxSubscriptions int
+
+	// Market data provider for data fetching
+	provider clients.MarketDataProvider
+
+	// Optional live trade feed; REST polling covers any time it is down
+	stream clients.TradeStream
+
+	// Optional coordination with other instances, which leaves upstream
+	// polling and the trade feed to one leader
+	cluster *Cluster
 
 	// Cache for data storage
 	cache cache.Cache
 
-	// Message buffer for throttling
-	messageBuffer map[string]*models.Quote
-	bufferMutex   sync.RWMutex
+	// Per-channel message buffers for throttling
+	channels map[Channel]*channelBuffer
+
+	// What happens to clients that fall behind
+	slowClientPolicy SlowClientPolicy
+	disconnectAfter  time.Duration
+
+	// Messages larger than this many bytes are compressed, zero disabling
+	// compression
+	compressAbove int
+
+	// Sequence numbers and replay buffers per topic, and the highest
+	// sequence number of any removed topic
+	topics          map[topic]*topicState
+	retiredSeq      uint64
+	topicsMutex     sync.Mutex
+	replaySize      int
+	replayRetention time.Duration
+	epoch           string
+
+	// Most recent quote per symbol, used as the base for applying trades
+	latestQuotes map[string]*models.Quote
+	quotesMutex  sync.RWMutex
+
+	// Live one-minute bar per symbol
+	bars      map[string]*models.Bar
+	barsMutex sync.Mutex
+
+	// Last polled order book per subscribed symbol, the base for diffs,
+	// and when it was fetched
+	orderBooks map[string]*models.OrderBook
+	bookTimes  map[string]time.Time
+	booksMutex sync.Mutex
+
+	// Order book and news polls in flight
+	polling   map[topic]bool
+	pollMutex sync.Mutex
+
+	// Articles already seen per subscribed symbol
+	newsSeen  map[string]map[string]bool
+	newsMutex sync.Mutex
 
 	// Control channels
 	shutdown chan struct{}
@@ -61,19 +114,38 @@ type Client struct {
 	// WebSocket connection
 	conn *websocket.Conn
 
-	// Buffered channel of outbound messages
-	send chan []byte
+	// Outbound messages not yet taken by writePump. ready signals that
+	// there are some and done that the client is closed.
+	queue []outbound
+	ready chan struct{}
+	done  chan struct{}
+
+	// Since when queue has been full, zero while it isn't, and how many
+	// updates were dropped or conflated because of it
+	behindSince time.Time
+	dropped     int64
+	conflated   int64
 
-	// Client subscriptions
-	subscriptions map[string]bool
+	// Client subscriptions, guarded by mutex and kept in step with the
+	// hub's registry
+	subscriptions map[topic]*subscription
+
+	// closed is set once done is closed; mutex guards it along with the
+	// queue and subscriptions so the hub can send from any goroutine
+	closed bool
+	mutex  sync.Mutex
 
 	// Hub reference
 	hub *Hub
 
+	// Wire format negotiated for the connection
+	format wireFormat
+
 	// Client metadata
-	id        string
-	userAgent string
-	ipAddress string
+	id          string
+	userAgent   string
+	ipAddress   string
+	connectedAt time.Time
 
 	// Rate limiting
 	lastMessageTime time.Time
@@ -89,20 +161,35 @@ type HubMetrics struct {
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub(finnhubClient *clients.FinnhubClient, cache cache.Cache) *Hub {
+func NewHub(provider clients.MarketDataProvider, cache cache.Cache) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	return &Hub{
-		clients:       make(map[*Client]bool),
-		register:      make(chan *Client),
-		unregister:    make(chan *Client),
-		subscriptions: make(map[string]map[*Client]bool),
-		finnhubClient: finnhubClient,
-		cache:         cache,
-		messageBuffer: make(map[string]*models.Quote),
-		shutdown:      make(chan struct{}),
-		ctx:           ctx,
-		cancel:        cancel,
+		clients:          make(map[*Client]bool),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		listClients:      make(chan chan []*Client),
+		subscriptions:    newRegistry(),
+		maxSubscriptions: defaultMaxSubscriptions,
+		provider:         provider,
+		cache:            cache,
+		channels:         newChannelBuffers(),
+		slowClientPolicy: SlowClientConflate,
+		disconnectAfter:  defaultDisconnectAfter,
+		compressAbove:    defaultCompressAbove,
+		topics:           make(map[topic]*topicState),
+		replaySize:       defaultReplaySize,
+		replayRetention:  defaultReplayRetention,
+		epoch:            newEpoch(),
+		latestQuotes:     make(map[string]*models.Quote),
+		bars:             make(map[string]*models.Bar),
+		orderBooks:       make(map[string]*models.OrderBook),
+		bookTimes:        make(map[string]time.Time),
+		polling:          make(map[topic]bool),
+		newsSeen:         make(map[string]map[string]bool),
+		shutdown:         make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
 		metrics: &HubMetrics{
 			ConnectedClients:   0,
 			TotalSubscriptions: 0,
@@ -111,14 +198,54 @@ func NewHub(finnhubClient *clients.FinnhubClient, cache cache.Cache) *Hub {
 	}
 }
 
+// SetTradeStream attaches a live trade feed. It must be called before Run.
+// Without a cluster the caller runs the stream; with one the hub runs it
+// once this instance first fetches upstream.
+func (h *Hub) SetTradeStream(stream clients.TradeStream) {
+	h.stream = stream
+}
+
+// SetCluster shares upstream polling with other instances. It must be
+// called before Run.
+func (h *Hub) SetCluster(cluster *Cluster) {
+	h.cluster = cluster
+}
+
+// SetMaxSubscriptions caps how many symbols each client may subscribe to.
+// It must be called before Run.
+func (h *Hub) SetMaxSubscriptions(limit int) {
+	if limit > 0 {
+		h.maxSubscriptions = limit
+	}
+}
+
 // Run starts the hub
 func (h *Hub) Run() {
-	// Start message buffer flusher
-	go h.flushMessageBuffer()
+	// Start a buffer flusher per channel
+	for _, buffer := range h.channels {
+		go h.flushChannel(buffer)
+	}
 
 	// Start periodic quote updates
 	go h.periodicQuoteUpdates()
 
+	// Start polling order books and news
+	go h.produceOrderBooks()
+	go h.produceNews()
+
+	// Start expiring replay buffers of abandoned topics
+	go h.expireTopics()
+
+	// Start applying streamed trades
+	if h.stream != nil {
+		go h.consumeTrades()
+	}
+
+	// Start coordinating with other instances
+	if h.cluster != nil {
+		go h.runCluster()
+	}
+
 	for {
 		select {
 		case client := <-h.register:
@@ -127,6 +254,13 @@ func (h *Hub) Run() {
 		case client := <-h.unregister:
 			h.unregisterClient(client)
 
+		case reply := <-h.listClients:
+			connected := make([]*Client, 0, len(h.clients))
+			for client := range h.clients {
+				connected = append(connected, client)
+			}
+			reply <- connected
+
 		case <-h.shutdown:
 			log.Println("Hub shutting down...")
 			h.cancel()
@@ -143,22 +277,47 @@ func (h *Hub) Shutdown() {
 	close(h.shutdown)
 }
 
-// HandleWebSocket handles WebSocket connections
+// HandleWebSocket handles WebSocket connections. Clients choose a wire
+// format with a subprotocol such as equity.v1.msgpack, or with a format
+// query parameter if they can't set one; JSON is the default.
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	format := formatJSON
+	if name := r.URL.Query().Get("format"); name != "" {
+		var ok bool
+		if format, ok = parseWireFormat(name); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "unsupported_format",
+				Message: fmt.Sprintf("Unknown format %q, use json, msgpack or protobuf", name),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+
+	upgrader := upgrader
+	upgrader.EnableCompression = h.compressAbove > 0
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
+	if protocol := conn.Subprotocol(); protocol != "" {
+		format, _ = parseWireFormat(protocol)
+	}
 
 	client := &Client{
 		conn:          conn,
-		send:          make(chan []byte, 256),
-		subscriptions: make(map[string]bool),
+		format:        format,
+		ready:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		subscriptions: make(map[topic]*subscription),
 		hub:           h,
 		id:            generateClientID(),
 		userAgent:     r.Header.Get("User-Agent"),
 		ipAddress:     r.RemoteAddr,
+		connectedAt:   time.Now(),
 		lastMessageTime: time.Now(),
 		messageCount:    0,
 	}
@@ -171,24 +330,29 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
+// TopSymbols returns up to limit symbols with the most subscribers, most
+// subscribed first. A limit of zero returns them all.
+func (h *Hub) TopSymbols(limit int) []string {
+	return h.subscriptions.top(limit)
+}
+
 func (h *Hub) registerClient(client *Client) {
 	h.clients[client] = true
 
 	// Send welcome message
 	welcomeMsg := models.WebSocketMessage{
 		Type:      "welcome",
-		Data:      map[string]interface{}{"clientId": client.id, "serverTime": time.Now()},
+		Data: models.Welcome{
+			ClientID:         client.id,
+			ServerTime:       time.Now(),
+			ProtocolVersion:  ProtocolVersion,
+			Format:           client.format.String(),
+			Epoch:            h.epoch,
+			MaxSubscriptions: h.maxSubscriptions,
+		},
 		Timestamp: time.Now(),
 	}
-
-	if data, err := json.Marshal(welcomeMsg); err == nil {
-		select {
-		case client.send <- data:
-		default:
-			close(client.send)
-			delete(h.clients, client)
-		}
-	}
+	client.trySend(newWireMessage(welcomeMsg))
 
 	h.metrics.mutex.Lock()
 	h.metrics.ConnectedClients = len(h.clients)
@@ -199,13 +363,14 @@ func (h *Hub) registerClient(client *Client) {
 
 func (h *Hub) unregisterClient(client *Client) {
 	if _, ok := h.clients[client]; ok {
-		// Remove from all subscriptions
-		for symbol := range client.subscriptions {
-			h.unsubscribeClientFromSymbol(client, symbol)
+		// Close first so no new subscriptions can be added, then remove
+		// from all subscriptions
+		client.close()
+		for _, t := range client.subscribedTopics() {
+			h.unsubscribeClient(client, t.channel, t.symbol)
 		}
 
 		delete(h.clients, client)
-		close(client.send)
 
 		h.metrics.mutex.Lock()
 		h.metrics.ConnectedClients = len(h.clients)
@@ -215,107 +380,121 @@ func (h *Hub) unregisterClient(client *Client) {
 	}
 }
 
-func (h *Hub) subscribeClientToSymbol(client *Client, symbol string) {
-	// Add client to symbol subscription
-	if h.subscriptions[symbol] == nil {
-		h.subscriptions[symbol] = make(map[*Client]bool)
+// subscribeClient subscribes client to a channel of a symbol, sending it
+// updates at most once per interval. It returns false if the client
+// already was; otherwise the caller syncs the client.
+func (h *Hub) subscribeClient(client *Client, channel Channel, symbol string, interval time.Duration) bool {
+	t := topic{channel, symbol}
+	added := h.subscriptions.subscribe(client, t, interval, func(active []Channel) {
+		h.syncStream(symbol, active)
+	})
+	if !added {
+		return false
 	}
-
-	h.subscriptions[symbol][client] = true
-	client.subscriptions[symbol] = true
+	h.refreshTopic(t)
 
 	h.metrics.mutex.Lock()
 	h.metrics.TotalSubscriptions++
 	h.metrics.mutex.Unlock()
 
-	log.Printf("Client %s subscribed to %s", client.id, symbol)
+	log.Printf("Client %s subscribed to %s %s", client.id, symbol, channel)
 
-	// Send current quote immediately
-	go func() {
-		if quote, err := h.finnhubClient.GetQuote(h.ctx, symbol); err == nil {
-			h.bufferQuoteUpdate(symbol, quote)
-		}
-	}()
+	// Record what news exists now so the next poll reports arrivals. In a
+	// cluster the leader's next poll does.
+	if channel == ChannelNews && h.cluster == nil {
+		go h.fetchNews(symbol)
+	}
+	return true
 }
 
-func (h *Hub) unsubscribeClientFromSymbol(client *Client, symbol string) {
-	if subscribers, exists := h.subscriptions[symbol]; exists {
-		if subscribers[client] {
-			delete(subscribers, client)
-			delete(client.subscriptions, symbol)
+func (h *Hub) unsubscribeClient(client *Client, channel Channel, symbol string) {
+	t := topic{channel, symbol}
+	removed := h.subscriptions.unsubscribe(client, t, func(active []Channel) {
+		h.syncStream(symbol, active)
+	})
+	if !removed {
+		return
+	}
+	h.refreshTopic(t)
 
-			// Remove subscription if no more clients
-			if len(subscribers) == 0 {
-				delete(h.subscriptions, symbol)
-			}
+	h.metrics.mutex.Lock()
+	h.metrics.TotalSubscriptions--
+	h.metrics.mutex.Unlock()
 
-			h.metrics.mutex.Lock()
-			h.metrics.TotalSubscriptions--
-			h.metrics.mutex.Unlock()
+	log.Printf("Client %s unsubscribed from %s %s", client.id, symbol, channel)
+}
+
+// syncStream streams a symbol while any market channel of it has
+// subscribers. In a cluster the leader streams every instance's symbols
+// instead. It runs under the registry's locks, which is fine because
+// stream subscription changes don't block.
+func (h *Hub) syncStream(symbol string, active []Channel) {
+	if h.stream == nil || h.cluster != nil {
+		return
+	}
 
-			log.Printf("Client %s unsubscribed from %s", client.id, symbol)
+	for _, channel := range active {
+		for _, market := range marketChannels {
+			if channel == market {
+				h.stream.Subscribe(symbol)
+				return
+			}
 		}
 	}
+	h.stream.Unsubscribe(symbol)
 }
 
-func (h *Hub) bufferQuoteUpdate(symbol string, quote *models.Quote) {
-	h.bufferMutex.Lock()
-	h.messageBuffer[symbol] = quote
-	h.bufferMutex.Unlock()
+// marketSymbols returns the symbols this instance needs quotes and trades
+// for
+func (h *Hub) marketSymbols() []string {
+	return h.subscriptions.symbols(marketChannels...)
 }
 
-// Flush message buffer every 500ms to throttle updates
-func (h *Hub) flushMessageBuffer() {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+func (h *Hub) bufferQuoteUpdate(symbol string, quote *models.Quote) {
+	h.quotesMutex.Lock()
+	h.latestQuotes[symbol] = quote
+	h.quotesMutex.Unlock()
 
+	h.publish(ChannelQuotes, symbol, quote)
+}
+
+// consumeTrades turns streamed trades into trade, bar and quote updates
+func (h *Hub) consumeTrades() {
 	for {
 		select {
-		case <-ticker.C:
-			h.flushBuffer()
+		case trade := <-h.stream.Trades():
+			h.emitTrade(trade)
 		case <-h.ctx.Done():
 			return
 		}
 	}
 }
 
-func (h *Hub) flushBuffer() {
-	h.bufferMutex.Lock()
-	updates := make(map[string]*models.Quote)
-	for symbol, quote := range h.messageBuffer {
-		updates[symbol] = quote
+// applyTrade derives a quote from the latest known quote and a trade
+func (h *Hub) applyTrade(trade models.Trade) *models.Quote {
+	h.quotesMutex.RLock()
+	base := h.latestQuotes[trade.Symbol]
+	h.quotesMutex.RUnlock()
+
+	quote := models.Quote{Symbol: trade.Symbol}
+	if base != nil {
+		quote = *base
 	}
-	// Clear buffer
-	h.messageBuffer = make(map[string]*models.Quote)
-	h.bufferMutex.Unlock()
-
-	// Send updates to subscribers
-	for symbol, quote := range updates {
-		if subscribers, exists := h.subscriptions[symbol]; exists && len(subscribers) > 0 {
-			message := models.WebSocketMessage{
-				Type:      "quote",
-				Symbol:    symbol,
-				Data:      quote,
-				Timestamp: time.Now(),
-			}
 
-			if data, err := json.Marshal(message); err == nil {
-				for client := range subscribers {
-					select {
-					case client.send <- data:
-					default:
-						// Client's send channel is full, disconnect them
-						h.unregisterClient(client)
-					}
-				}
-			}
-		}
+	quote.CurrentPrice = trade.Price
+	quote.Timestamp = trade.Timestamp
+	if quote.PreviousClose > 0 {
+		quote.Change = trade.Price - quote.PreviousClose
+		quote.PercentChange = quote.Change / quote.PreviousClose * 100
+	}
+	if quote.High == 0 || trade.Price > quote.High {
+		quote.High = trade.Price
+	}
+	if quote.Low == 0 || trade.Price < quote.Low {
+		quote.Low = trade.Price
 	}
 
-	// Update metrics
-	h.metrics.mutex.Lock()
-	h.metrics.MessagesPerSecond = float64(len(updates)) / 0.5
-	h.metrics.mutex.Unlock()
+	return &quote
 }
 
 // Periodic quote updates for subscribed symbols
@@ -326,19 +505,24 @@ func (h *Hub) periodicQuoteUpdates() {
 	for {
 		select {
 		case <-ticker.C:
-			// Get all subscribed symbols
-			symbols := make([]string, 0)
-			for symbol := range h.subscriptions {
-				if len(h.subscriptions[symbol]) > 0 {
-					symbols = append(symbols, symbol)
-				}
+			// The trade stream supplies updates while it is connected
+			if h.stream != nil && h.stream.Connected() {
+				continue
+			}
+
+			// Get all symbols this instance polls for
+			var symbols []string
+			if h.cluster != nil {
+				symbols = h.cluster.ownedSymbols(feedMarket)
+			} else {
+				symbols = h.marketSymbols()
 			}
 
 			// Fetch quotes for all symbols
 			for _, symbol := range symbols {
 				go func(sym string) {
-					if quote, err := h.finnhubClient.GetQuote(h.ctx, sym); err == nil {
-						h.bufferQuoteUpdate(sym, quote)
+					if quote, err := h.provider.GetQuote(h.ctx, sym); err == nil {
+						h.emitQuote(quote)
 					}
 				}(symbol)
 			}
@@ -0,0 +1,237 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// newTestHub returns a hub that isn't running, with a quote for each
+// symbol so quote snapshots never reach the provider
+func newTestHub(symbols ...string) *Hub {
+	h := NewHub(nil, nil)
+	for _, symbol := range symbols {
+		h.handleQuote(&models.Quote{Symbol: symbol, CurrentPrice: 100, Open: 99})
+	}
+	return h
+}
+
+// publishQuote publishes a quote and flushes the quotes channel
+func publishQuote(h *Hub, symbol string, price float64) *models.Quote {
+	quote := &models.Quote{Symbol: symbol, CurrentPrice: price, Open: 99}
+	h.handleQuote(quote)
+	h.flushBuffer(h.channels[ChannelQuotes])
+	return quote
+}
+
+// subscribeSynced subscribes c to t and syncs it the way handleSubscribe
+// does, returning what the sync sent
+func subscribeSynced(t *testing.T, h *Hub, c *Client, tp topic, resume resumePoint) []*wireMessage {
+	t.Helper()
+	if !h.subscribeClient(c, tp.channel, tp.symbol, 0) {
+		t.Fatalf("subscribe %s to %v returned false", c.id, tp)
+	}
+	h.syncClient(c, tp, resume)
+	return c.takeQueued()
+}
+
+func TestHubSubscribeFlushUnsubscribe(t *testing.T) {
+	h := newTestHub("AAPL")
+	c := newTestClient(h, "a")
+	quotes := topic{ChannelQuotes, "AAPL"}
+
+	synced := subscribeSynced(t, h, c, quotes, resumePoint{})
+	if len(synced) != 1 || !synced[0].message.Snapshot {
+		t.Fatalf("sync sent %d messages, want one snapshot", len(synced))
+	}
+	snapshotSeq := synced[0].message.Seq
+
+	publishQuote(h, "AAPL", 101)
+	sent := c.takeQueued()
+	if len(sent) != 1 {
+		t.Fatalf("flush sent %d messages, want 1", len(sent))
+	}
+	update := sent[0].message
+	if update.Seq != snapshotSeq+1 {
+		t.Fatalf("update seq = %d, want %d", update.Seq, snapshotSeq+1)
+	}
+	delta, ok := update.Data.(models.QuoteDelta)
+	if !ok {
+		t.Fatalf("update data is %T, want models.QuoteDelta", update.Data)
+	}
+	if delta.CurrentPrice == nil || *delta.CurrentPrice != 101 || delta.Open != nil {
+		t.Fatalf("delta = %+v, want only the price changed", delta)
+	}
+
+	h.unsubscribeClient(c, ChannelQuotes, "AAPL")
+	publishQuote(h, "AAPL", 102)
+	if sent := c.takeQueued(); len(sent) != 0 {
+		t.Fatalf("unsubscribed client was sent %d messages", len(sent))
+	}
+
+	// The topic kept numbering, so resuming replays what was missed
+	missed := subscribeSynced(t, h, c, quotes, resumePoint{seq: update.Seq, ok: true})
+	if len(missed) != 1 || missed[0].message.Seq != update.Seq+1 || missed[0].message.Snapshot {
+		t.Fatalf("resume sent %d messages, want the one update after seq %d", len(missed), update.Seq)
+	}
+}
+
+func TestHubSharesQuoteDeltas(t *testing.T) {
+	h := newTestHub("AAPL")
+	quotes := topic{ChannelQuotes, "AAPL"}
+	a := newTestClient(h, "a")
+	b := newTestClient(h, "b")
+	behind := newTestClient(h, "behind")
+	for _, c := range []*Client{a, b, behind} {
+		subscribeSynced(t, h, c, quotes, resumePoint{})
+	}
+
+	// A dropped update leaves the client without a base for deltas
+	behind.mutex.Lock()
+	behind.dropUpdate(quotes)
+	behind.mutex.Unlock()
+
+	publishQuote(h, "AAPL", 101)
+	sentA, sentB, sentBehind := a.takeQueued(), b.takeQueued(), behind.takeQueued()
+	if len(sentA) != 1 || len(sentB) != 1 || len(sentBehind) != 1 {
+		t.Fatalf("sent %d, %d and %d messages, want one each", len(sentA), len(sentB), len(sentBehind))
+	}
+	if sentA[0] != sentB[0] {
+		t.Fatal("clients with the same base got separate delta messages")
+	}
+	if sentBehind[0] == sentA[0] {
+		t.Fatal("client without a base shares the others' delta")
+	}
+
+	whole := sentBehind[0].message.Data.(models.QuoteDelta)
+	if whole.Open == nil || whole.PreviousClose == nil {
+		t.Fatalf("quote after a drop = %+v, want every field set", whole)
+	}
+	if sentBehind[0].message.Seq != sentA[0].message.Seq {
+		t.Fatal("clients got the same update with different seqs")
+	}
+
+	// An unchanged quote sends nothing
+	publishQuote(h, "AAPL", 101)
+	if sent := a.takeQueued(); len(sent) != 0 {
+		t.Fatalf("unchanged quote sent %d messages", len(sent))
+	}
+}
+
+func TestHubRemovesIdleTopics(t *testing.T) {
+	h := newTestHub("AAPL")
+	c := newTestClient(h, "a")
+	quotes := topic{ChannelQuotes, "AAPL"}
+
+	subscribeSynced(t, h, c, quotes, resumePoint{})
+	publishQuote(h, "AAPL", 101)
+	last := c.takeQueued()[0].message.Seq
+	h.unsubscribeClient(c, ChannelQuotes, "AAPL")
+
+	h.removeIdleTopics(time.Now())
+	if !h.hasTopic(quotes) {
+		t.Fatal("topic removed before the retention ran out")
+	}
+	h.removeIdleTopics(time.Now().Add(h.replayRetention))
+	if h.hasTopic(quotes) {
+		t.Fatal("idle topic kept past the retention")
+	}
+
+	// A topic that comes back never reuses sequence numbers
+	synced := subscribeSynced(t, h, c, quotes, resumePoint{seq: last, ok: true})
+	if len(synced) != 1 || !synced[0].message.Snapshot {
+		t.Fatal("resuming a removed topic didn't send a snapshot")
+	}
+	if seq := synced[0].message.Seq; seq <= last {
+		t.Fatalf("snapshot seq = %d after removal, want more than %d", seq, last)
+	}
+}
+
+// TestHubConcurrentSubscribeFlush subscribes, syncs and unsubscribes
+// clients while updates are flushed and idle topics removed, for go test
+// -race. Each client must see a topic's seqs in order.
+func TestHubConcurrentSubscribeFlush(t *testing.T) {
+	symbols := []string{"AAPL", "MSFT", "TSLA", "AMZN"}
+	h := newTestHub(symbols...)
+	h.SetReplay(10, time.Millisecond)
+	channels := []Channel{ChannelQuotes, ChannelTrades, ChannelCandles}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	loop := func(step func(n int)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				case <-time.After(100 * time.Microsecond):
+				}
+				step(n)
+			}
+		}()
+	}
+
+	loop(func(n int) {
+		symbol := symbols[n%len(symbols)]
+		price := 100 + float64(n%7)
+		h.handleQuote(&models.Quote{Symbol: symbol, CurrentPrice: price})
+		h.handleTrade(models.Trade{Symbol: symbol, Price: price, Volume: 1, Timestamp: time.Now()})
+	})
+	loop(func(int) {
+		for _, channel := range channels {
+			h.flushBuffer(h.channels[channel])
+		}
+	})
+	loop(func(int) {
+		h.removeIdleTopics(time.Now())
+	})
+
+	var clients sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		clients.Add(1)
+		go func(i int) {
+			defer clients.Done()
+			c := newTestClient(h, fmt.Sprint(i))
+			lastSeq := make(map[topic]uint64)
+			drain := func() {
+				for _, msg := range c.takeQueued() {
+					m := msg.message
+					tp := topic{Channel(m.Channel), m.Symbol}
+					if m.Seq < lastSeq[tp] {
+						t.Errorf("client %s got seq %d on %v after %d", c.id, m.Seq, tp, lastSeq[tp])
+					}
+					lastSeq[tp] = m.Seq
+				}
+			}
+
+			for n := 0; n < 100; n++ {
+				tp := topic{channels[(i+n)%len(channels)], symbols[(i+n/3)%len(symbols)]}
+				if h.subscribeClient(c, tp.channel, tp.symbol, 0) {
+					h.syncClient(c, tp, resumePoint{seq: lastSeq[tp], ok: lastSeq[tp] > 0})
+				}
+				// Give flushes time to reach the subscription
+				time.Sleep(time.Millisecond)
+				drain()
+				if n%2 == 1 {
+					h.unsubscribeClient(c, tp.channel, tp.symbol)
+				}
+			}
+			for _, tp := range c.subscribedTopics() {
+				h.unsubscribeClient(c, tp.channel, tp.symbol)
+			}
+			drain()
+		}(i)
+	}
+	clients.Wait()
+	close(stop)
+	background.Wait()
+
+	if got := h.subscriptions.symbols(); len(got) != 0 {
+		t.Fatalf("symbols() = %v after everyone left, want none", got)
+	}
+}
@@ -0,0 +1,332 @@
+package hub
+
+import (
+	"sort"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// Order book update kinds
+const (
+	orderBookSnapshot = "snapshot"
+	orderBookDiff     = "diff"
+)
+
+// handleQuote delivers a polled or published quote to the quotes channel
+// and folds its price into the live bar
+func (h *Hub) handleQuote(quote *models.Quote) {
+	h.bufferQuoteUpdate(quote.Symbol, quote)
+	if quote.CurrentPrice > 0 {
+		at := quote.Timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		h.updateBar(quote.Symbol, quote.CurrentPrice, 0, at)
+	}
+}
+
+// handleTrade delivers a streamed or published trade to the trades channel,
+// adds it to the live bar and derives a quote from it
+func (h *Hub) handleTrade(trade models.Trade) {
+	h.publish(ChannelTrades, trade.Symbol, []models.Trade{trade})
+	h.updateBar(trade.Symbol, trade.Price, trade.Volume, trade.Timestamp)
+	h.bufferQuoteUpdate(trade.Symbol, h.applyTrade(trade))
+}
+
+// updateBar adds a price, and the volume traded at it, to the symbol's
+// one-minute bar, starting a new bar when the minute rolls over. Prices
+// from before the current bar are ignored.
+func (h *Hub) updateBar(symbol string, price, volume float64, at time.Time) {
+	start := at.Truncate(time.Minute).Unix()
+
+	h.barsMutex.Lock()
+	defer h.barsMutex.Unlock()
+
+	bar := h.bars[symbol]
+	switch {
+	case bar == nil || start > bar.Time:
+		bar = &models.Bar{Symbol: symbol, Time: start, Open: price, High: price, Low: price}
+		h.bars[symbol] = bar
+	case start < bar.Time:
+		return
+	}
+
+	if price > bar.High {
+		bar.High = price
+	}
+	if price < bar.Low {
+		bar.Low = price
+	}
+	bar.Close = price
+	bar.Volume += volume
+
+	// Publish under the lock so bars reach the buffer in order
+	h.publish(ChannelCandles, symbol, []models.Bar{*bar})
+}
+
+// polledSymbols returns the symbols to poll channel for: those subscribed
+// here, or in a cluster those this instance fetches for every instance
+func (h *Hub) polledSymbols(channel Channel, feed clusterFeed) []string {
+	if h.cluster != nil {
+		return h.cluster.ownedSymbols(feed)
+	}
+	return h.subscriptions.symbols(channel)
+}
+
+// startPoll marks a poll of t as in flight, returning false if one already
+// is, so a poll slower than its interval is skipped rather than overlapped
+func (h *Hub) startPoll(t topic) bool {
+	h.pollMutex.Lock()
+	defer h.pollMutex.Unlock()
+
+	if h.polling[t] {
+		return false
+	}
+	h.polling[t] = true
+	return true
+}
+
+func (h *Hub) endPoll(t topic) {
+	h.pollMutex.Lock()
+	delete(h.polling, t)
+	h.pollMutex.Unlock()
+}
+
+// produceOrderBooks polls the order book of every subscribed symbol and
+// publishes what changed
+func (h *Hub) produceOrderBooks() {
+	ticker := time.NewTicker(h.channels[ChannelOrderBook].interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.pruneOrderBooks(h.subscriptions.symbols(ChannelOrderBook))
+
+			for _, symbol := range h.polledSymbols(ChannelOrderBook, feedOrderBook) {
+				go h.fetchOrderBook(symbol)
+			}
+
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// fetchOrderBook polls the order book for symbol unless a poll already is
+// in flight
+func (h *Hub) fetchOrderBook(symbol string) {
+	t := topic{ChannelOrderBook, symbol}
+	if !h.startPoll(t) {
+		return
+	}
+	defer h.endPoll(t)
+
+	fetchedAt := time.Now()
+	if book, err := h.provider.GetOrderBook(h.ctx, symbol); err == nil {
+		h.emitOrderBook(symbol, book, fetchedAt)
+	}
+}
+
+// updateOrderBook records a book fetched at fetchedAt and publishes a diff
+// against the previous one, or a snapshot if there was none. A book
+// fetched before the one recorded is dropped, so diffs never go backwards.
+func (h *Hub) updateOrderBook(symbol string, book *models.OrderBook, fetchedAt time.Time) {
+	h.booksMutex.Lock()
+	defer h.booksMutex.Unlock()
+
+	if fetchedAt.Before(h.bookTimes[symbol]) {
+		return
+	}
+	prev := h.orderBooks[symbol]
+	h.orderBooks[symbol] = book
+	h.bookTimes[symbol] = fetchedAt
+
+	// Publish under the lock so diffs reach the buffer in order
+	if prev == nil {
+		h.publish(ChannelOrderBook, symbol, orderBookUpdate(symbol, book))
+		return
+	}
+
+	bids := diffLevels(prev.Bids, book.Bids, true)
+	asks := diffLevels(prev.Asks, book.Asks, false)
+	if len(bids) > 0 || len(asks) > 0 {
+		h.publish(ChannelOrderBook, symbol, models.OrderBookUpdate{
+			Symbol: symbol,
+			Kind:   orderBookDiff,
+			Bids:   bids,
+			Asks:   asks,
+		})
+	}
+}
+
+// pruneOrderBooks forgets books nobody is subscribed to any more
+func (h *Hub) pruneOrderBooks(subscribed []string) {
+	keep := make(map[string]bool, len(subscribed))
+	for _, symbol := range subscribed {
+		keep[symbol] = true
+	}
+
+	h.booksMutex.Lock()
+	for symbol := range h.orderBooks {
+		if !keep[symbol] {
+			delete(h.orderBooks, symbol)
+			delete(h.bookTimes, symbol)
+		}
+	}
+	h.booksMutex.Unlock()
+}
+
+func orderBookUpdate(symbol string, book *models.OrderBook) models.OrderBookUpdate {
+	return models.OrderBookUpdate{
+		Symbol: symbol,
+		Kind:   orderBookSnapshot,
+		Bids:   book.Bids,
+		Asks:   book.Asks,
+	}
+}
+
+// diffLevels returns the levels of next whose volume differs from prev,
+// plus a zero-volume level for each price that is gone, sorted like the
+// side they are on
+func diffLevels(prev, next []models.PriceLevel, descending bool) []models.PriceLevel {
+	before := make(map[float64]int64, len(prev))
+	for _, level := range prev {
+		before[level.Price] = level.Volume
+	}
+
+	changed := make([]models.PriceLevel, 0)
+	for _, level := range next {
+		if volume, ok := before[level.Price]; !ok || volume != level.Volume {
+			changed = append(changed, level)
+		}
+		delete(before, level.Price)
+	}
+	for price := range before {
+		changed = append(changed, models.PriceLevel{Price: price})
+	}
+	sortLevels(changed, descending)
+	return changed
+}
+
+// applyLevels applies changed levels to levels. Removed levels are dropped
+// from a snapshot but kept, with zero volume, in a diff so the client still
+// hears about them. Bids are descending and asks ascending.
+func applyLevels(levels, changes []models.PriceLevel, snapshot, descending bool) []models.PriceLevel {
+	volumes := make(map[float64]int64, len(levels)+len(changes))
+	for _, level := range levels {
+		volumes[level.Price] = level.Volume
+	}
+	for _, level := range changes {
+		volumes[level.Price] = level.Volume
+	}
+
+	merged := make([]models.PriceLevel, 0, len(volumes))
+	for price, volume := range volumes {
+		if snapshot && volume == 0 {
+			continue
+		}
+		merged = append(merged, models.PriceLevel{Price: price, Volume: volume})
+	}
+	sortLevels(merged, descending)
+	return merged
+}
+
+func sortLevels(levels []models.PriceLevel, descending bool) {
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+}
+
+// produceNews polls news for every subscribed symbol and publishes articles
+// it hasn't seen. The first poll for a symbol only records what exists, so
+// subscribers hear about arrivals rather than the backlog, which they can
+// fetch over REST.
+func (h *Hub) produceNews() {
+	ticker := time.NewTicker(h.channels[ChannelNews].interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.pruneNews(h.subscriptions.symbols(ChannelNews))
+
+			for _, symbol := range h.polledSymbols(ChannelNews, feedNews) {
+				go h.fetchNews(symbol)
+			}
+
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// fetchNews polls the last day of news for symbol unless a poll already is
+// in flight
+func (h *Hub) fetchNews(symbol string) {
+	t := topic{ChannelNews, symbol}
+	if !h.startPoll(t) {
+		return
+	}
+	defer h.endPoll(t)
+
+	now := time.Now()
+	from := now.AddDate(0, 0, -1).Format("2006-01-02")
+	if items, err := h.provider.GetNews(h.ctx, symbol, from, now.Format("2006-01-02")); err == nil {
+		h.emitNews(symbol, items)
+	}
+}
+
+// updateNews publishes the articles in items not seen before, oldest first
+func (h *Hub) updateNews(symbol string, items []models.NewsItem) {
+	h.newsMutex.Lock()
+	defer h.newsMutex.Unlock()
+
+	seen, primed := h.newsSeen[symbol]
+	if !primed {
+		seen = make(map[string]bool, len(items))
+		h.newsSeen[symbol] = seen
+	}
+
+	arrived := make([]models.NewsItem, 0)
+	for _, item := range items {
+		key := item.ID
+		if key == "" {
+			key = item.URL
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if primed {
+			arrived = append(arrived, item)
+		}
+	}
+
+	if len(arrived) > 0 {
+		sort.Slice(arrived, func(i, j int) bool {
+			return arrived[i].DateTime.Before(arrived[j].DateTime)
+		})
+		h.publish(ChannelNews, symbol, arrived)
+	}
+}
+
+// pruneNews forgets seen articles for symbols nobody follows any more
+func (h *Hub) pruneNews(subscribed []string) {
+	keep := make(map[string]bool, len(subscribed))
+	for _, symbol := range subscribed {
+		keep[symbol] = true
+	}
+
+	h.newsMutex.Lock()
+	for symbol := range h.newsSeen {
+		if !keep[symbol] {
+			delete(h.newsSeen, symbol)
+		}
+	}
+	h.newsMutex.Unlock()
+}
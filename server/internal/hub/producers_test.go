@@ -0,0 +1,147 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"equity-server/internal/cache"
+	"equity-server/internal/clients"
+	"equity-server/internal/fakefinnhub"
+	"equity-server/internal/models"
+)
+
+// newsResponse is a Finnhub company-news list of the articles with ids from
+// newest down to oldest, newest first
+func newsResponse(symbol string, newest, oldest int) fakefinnhub.Response {
+	now := time.Now()
+	articles := make([]map[string]interface{}, 0)
+	for id := newest; id >= oldest; id-- {
+		articles = append(articles, map[string]interface{}{
+			"id":       id,
+			"datetime": now.Add(-time.Duration(newest-id) * time.Minute).Unix(),
+			"headline": fmt.Sprintf("%s headline %d", symbol, id),
+			"url":      "https://example.com/news",
+		})
+	}
+	return fakefinnhub.Response{Body: articles, Times: 1}
+}
+
+// pendingNews returns the articles waiting to be flushed for symbol
+func pendingNews(h *Hub, symbol string) []models.NewsItem {
+	buffer := h.channels[ChannelNews]
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	items, _ := buffer.pending[symbol].([]models.NewsItem)
+	return items
+}
+
+func TestHubPublishesArrivedNews(t *testing.T) {
+	fake := fakefinnhub.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	serializer, err := cache.NewSerializer(cache.MessagePack, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(cache.MemoryCacheOptions{})
+	store := cache.NewStore(memory, "test", serializer)
+	keys := clients.NewKeyPool([]string{"key"}, 6000, time.Minute)
+	h := NewHub(clients.NewFinnhubClient(keys, srv.URL+fakefinnhub.APIPrefix, store, nil, nil), nil)
+
+	// The first poll only records what exists
+	fake.Program(fakefinnhub.EndpointNews, "AAPL", newsResponse("AAPL", 110, 100))
+	h.fetchNews("AAPL")
+	if items := pendingNews(h, "AAPL"); len(items) != 0 {
+		t.Fatalf("first poll published %d articles, want none", len(items))
+	}
+
+	// A new article pushes the oldest off the capped list
+	memory.DeletePrefix(context.Background(), "")
+	fake.Program(fakefinnhub.EndpointNews, "AAPL", newsResponse("AAPL", 111, 101))
+	h.fetchNews("AAPL")
+
+	items := pendingNews(h, "AAPL")
+	if len(items) != 1 || items[0].Headline != "AAPL headline 111" {
+		t.Fatalf("second poll published %+v, want only the new article", items)
+	}
+}
+
+// slowBooks is a provider whose order book fetches block until released
+type slowBooks struct {
+	clients.MarketDataProvider
+
+	calls   int32
+	release chan struct{}
+}
+
+func (p *slowBooks) GetOrderBook(ctx context.Context, symbol string) (*models.OrderBook, error) {
+	atomic.AddInt32(&p.calls, 1)
+	<-p.release
+	return &models.OrderBook{Symbol: symbol, Bids: []models.PriceLevel{{Price: 100, Volume: 1}}}, nil
+}
+
+func TestHubPollsOneOrderBookAtATime(t *testing.T) {
+	provider := &slowBooks{release: make(chan struct{})}
+	h := NewHub(provider, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.fetchOrderBook("AAPL")
+	}()
+	for atomic.LoadInt32(&provider.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Polls while the first is in flight are skipped
+	for i := 0; i < 3; i++ {
+		h.fetchOrderBook("AAPL")
+	}
+	close(provider.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&provider.calls); calls != 1 {
+		t.Fatalf("provider fetched the book %d times at once, want 1", calls)
+	}
+
+	// Once the poll is done the next one goes ahead
+	h.fetchOrderBook("AAPL")
+	if calls := atomic.LoadInt32(&provider.calls); calls != 2 {
+		t.Fatalf("provider fetched the book %d times, want 2", calls)
+	}
+}
+
+func TestHubDropsOlderOrderBooks(t *testing.T) {
+	h := NewHub(nil, nil)
+	book := func(price float64) *models.OrderBook {
+		return &models.OrderBook{Symbol: "AAPL", Bids: []models.PriceLevel{{Price: price, Volume: 1}}}
+	}
+	now := time.Now()
+
+	h.updateOrderBook("AAPL", book(101), now)
+	h.updateOrderBook("AAPL", book(100), now.Add(-time.Second))
+
+	h.booksMutex.Lock()
+	price := h.orderBooks["AAPL"].Bids[0].Price
+	h.booksMutex.Unlock()
+	if price != 101 {
+		t.Fatalf("stored book bids at %v, want the newer book at 101", price)
+	}
+
+	// Only the first book was published
+	buffer := h.channels[ChannelOrderBook]
+	buffer.mutex.Lock()
+	update := buffer.pending["AAPL"].(models.OrderBookUpdate)
+	buffer.mutex.Unlock()
+	if update.Kind != orderBookSnapshot || update.Bids[0].Price != 101 {
+		t.Fatalf("pending update = %+v, want the snapshot at 101", update)
+	}
+}
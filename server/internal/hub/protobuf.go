@@ -0,0 +1,393 @@
+package hub
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"equity-server/internal/models"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of WebSocket messages, following
+// proto/equity/v1/stream.proto. Messages are written field by field so the
+// models need no generated code; keep the field numbers here in step with
+// the schema.
+
+// Envelope fields
+const (
+	envelopeType      protowire.Number = 1
+	envelopeID        protowire.Number = 2
+	envelopeChannel   protowire.Number = 3
+	envelopeSymbol    protowire.Number = 4
+	envelopeSeq       protowire.Number = 5
+	envelopeSnapshot  protowire.Number = 6
+	envelopeTimestamp protowire.Number = 7
+	envelopeQuote     protowire.Number = 10
+	envelopeTrades    protowire.Number = 11
+	envelopeOrderBook protowire.Number = 12
+	envelopeCandles   protowire.Number = 13
+	envelopeNews      protowire.Number = 14
+	envelopeWelcome   protowire.Number = 15
+	envelopeAck       protowire.Number = 16
+	envelopeError     protowire.Number = 17
+	envelopeDelta     protowire.Number = 18
+)
+
+// Request fields
+const (
+	requestFieldType     protowire.Number = 1
+	requestFieldID       protowire.Number = 2
+	requestFieldVersion  protowire.Number = 3
+	requestFieldChannel  protowire.Number = 4
+	requestFieldSymbol   protowire.Number = 5
+	requestFieldSymbols  protowire.Number = 6
+	requestFieldEpoch    protowire.Number = 7
+	requestFieldLastSeq  protowire.Number = 8
+	requestFieldLastSeqs protowire.Number = 9
+	requestFieldMaxRate  protowire.Number = 10
+)
+
+// marshalProtobuf encodes msg as an Envelope
+func marshalProtobuf(msg models.WebSocketMessage) ([]byte, error) {
+	var b []byte
+	b = appendString(b, envelopeType, msg.Type)
+	if msg.ID != nil {
+		b = appendString(b, envelopeID, fmt.Sprint(msg.ID))
+	}
+	b = appendString(b, envelopeChannel, msg.Channel)
+	b = appendString(b, envelopeSymbol, msg.Symbol)
+	b = appendUint(b, envelopeSeq, msg.Seq)
+	b = appendBool(b, envelopeSnapshot, msg.Snapshot)
+	b = appendTime(b, envelopeTimestamp, msg.Timestamp)
+
+	switch data := msg.Data.(type) {
+	case nil:
+	case *models.Quote:
+		if data != nil {
+			b = appendMessage(b, envelopeQuote, protoQuote(data))
+		}
+	case models.QuoteDelta:
+		b = appendMessage(b, envelopeDelta, protoQuoteDelta(data))
+	case []models.Trade:
+		var list []byte
+		for _, trade := range data {
+			list = appendMessage(list, 1, protoTrade(trade))
+		}
+		b = appendMessage(b, envelopeTrades, list)
+	case models.OrderBookUpdate:
+		b = appendMessage(b, envelopeOrderBook, protoOrderBook(data))
+	case []models.Bar:
+		var list []byte
+		for _, bar := range data {
+			list = appendMessage(list, 1, protoBar(bar))
+		}
+		b = appendMessage(b, envelopeCandles, list)
+	case []models.NewsItem:
+		var list []byte
+		for _, item := range data {
+			list = appendMessage(list, 1, protoNewsItem(item))
+		}
+		b = appendMessage(b, envelopeNews, list)
+	case models.Welcome:
+		b = appendMessage(b, envelopeWelcome, protoWelcome(data))
+	case models.SubscriptionAck:
+		b = appendMessage(b, envelopeAck, protoAck(data))
+	case models.ErrorResponse:
+		b = appendMessage(b, envelopeError, protoError(data))
+	default:
+		return nil, fmt.Errorf("no protobuf encoding for %T", msg.Data)
+	}
+	return b, nil
+}
+
+func protoQuote(q *models.Quote) []byte {
+	var b []byte
+	b = appendString(b, 1, q.Symbol)
+	b = appendDouble(b, 2, q.CurrentPrice)
+	b = appendDouble(b, 3, q.Change)
+	b = appendDouble(b, 4, q.PercentChange)
+	b = appendDouble(b, 5, q.High)
+	b = appendDouble(b, 6, q.Low)
+	b = appendDouble(b, 7, q.Open)
+	b = appendDouble(b, 8, q.PreviousClose)
+	b = appendTime(b, 9, q.Timestamp)
+	b = appendString(b, 10, q.Provider)
+	b = appendBool(b, 11, q.Stale)
+	return b
+}
+
+// protoQuoteDelta writes every field that is set, even at its zero value,
+// since a field's presence is what says it changed
+func protoQuoteDelta(d models.QuoteDelta) []byte {
+	var b []byte
+	b = appendString(b, 1, d.Symbol)
+	doubles := []*float64{d.CurrentPrice, d.Change, d.PercentChange, d.High, d.Low, d.Open, d.PreviousClose}
+	for i, v := range doubles {
+		if v != nil {
+			b = protowire.AppendTag(b, protowire.Number(i+2), protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(*v))
+		}
+	}
+	if d.Timestamp != nil {
+		b = appendTime(b, 9, *d.Timestamp)
+	}
+	if d.Provider != nil {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendString(b, *d.Provider)
+	}
+	if d.Stale != nil {
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*d.Stale))
+	}
+	return b
+}
+
+func protoTrade(t models.Trade) []byte {
+	var b []byte
+	b = appendString(b, 1, t.Symbol)
+	b = appendDouble(b, 2, t.Price)
+	b = appendDouble(b, 3, t.Volume)
+	b = appendTime(b, 4, t.Timestamp)
+	return b
+}
+
+func protoOrderBook(u models.OrderBookUpdate) []byte {
+	var b []byte
+	b = appendString(b, 1, u.Symbol)
+	b = appendString(b, 2, u.Kind)
+	for _, level := range u.Bids {
+		b = appendMessage(b, 3, protoPriceLevel(level))
+	}
+	for _, level := range u.Asks {
+		b = appendMessage(b, 4, protoPriceLevel(level))
+	}
+	return b
+}
+
+func protoPriceLevel(level models.PriceLevel) []byte {
+	var b []byte
+	b = appendDouble(b, 1, level.Price)
+	b = appendUint(b, 2, uint64(level.Volume))
+	return b
+}
+
+func protoBar(bar models.Bar) []byte {
+	var b []byte
+	b = appendString(b, 1, bar.Symbol)
+	b = appendUint(b, 2, uint64(bar.Time))
+	b = appendDouble(b, 3, bar.Open)
+	b = appendDouble(b, 4, bar.High)
+	b = appendDouble(b, 5, bar.Low)
+	b = appendDouble(b, 6, bar.Close)
+	b = appendDouble(b, 7, bar.Volume)
+	return b
+}
+
+func protoNewsItem(item models.NewsItem) []byte {
+	var b []byte
+	b = appendString(b, 1, item.ID)
+	b = appendString(b, 2, item.Headline)
+	b = appendString(b, 3, item.Summary)
+	b = appendString(b, 4, item.Source)
+	b = appendString(b, 5, item.URL)
+	b = appendString(b, 6, item.Image)
+	b = appendTime(b, 7, item.DateTime)
+	b = appendString(b, 8, item.Symbol)
+	b = appendString(b, 9, item.Provider)
+	b = appendBool(b, 10, item.Stale)
+	return b
+}
+
+func protoWelcome(w models.Welcome) []byte {
+	var b []byte
+	b = appendString(b, 1, w.ClientID)
+	b = appendTime(b, 2, w.ServerTime)
+	b = appendUint(b, 3, uint64(w.ProtocolVersion))
+	b = appendString(b, 4, w.Format)
+	b = appendString(b, 5, w.Epoch)
+	b = appendUint(b, 6, uint64(w.MaxSubscriptions))
+	return b
+}
+
+func protoAck(ack models.SubscriptionAck) []byte {
+	var b []byte
+	b = appendString(b, 1, ack.Request)
+	b = appendString(b, 2, ack.Channel)
+	for _, symbol := range ack.Symbols {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, symbol)
+	}
+
+	channels := make([]string, 0, len(ack.Channels))
+	for channel := range ack.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		var list []byte
+		for _, symbol := range ack.Channels[channel] {
+			list = protowire.AppendTag(list, 1, protowire.BytesType)
+			list = protowire.AppendString(list, symbol)
+		}
+		var entry []byte
+		entry = appendString(entry, 1, channel)
+		entry = appendMessage(entry, 2, list)
+		b = appendMessage(b, 4, entry)
+	}
+
+	b = appendUint(b, 5, uint64(ack.Subscriptions))
+	b = appendUint(b, 6, uint64(ack.Limit))
+	b = appendDouble(b, 7, ack.MaxRate)
+	return b
+}
+
+func protoError(e models.ErrorResponse) []byte {
+	var b []byte
+	b = appendString(b, 1, e.Error)
+	b = appendString(b, 2, e.Message)
+	b = appendUint(b, 3, uint64(e.Code))
+	return b
+}
+
+// Scalars at their zero value are left out, as proto3 does
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendUint(b, num, 1)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// appendTime writes t as Unix milliseconds
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendUint(b, num, uint64(t.UnixMilli()))
+}
+
+// appendMessage writes an embedded message, even an empty one, so the
+// field is present
+func appendMessage(b []byte, num protowire.Number, body []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, body)
+}
+
+// unmarshalProtobufRequest decodes a Request into msg. Unknown fields are
+// skipped.
+func unmarshalProtobufRequest(data []byte, msg *models.SubscriptionMessage) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == requestFieldType && typ == protowire.BytesType:
+			msg.Type, n = protowire.ConsumeString(data)
+		case num == requestFieldID && typ == protowire.BytesType:
+			var id string
+			if id, n = protowire.ConsumeString(data); id != "" {
+				msg.ID = id
+			}
+		case num == requestFieldVersion && typ == protowire.VarintType:
+			var version uint64
+			version, n = protowire.ConsumeVarint(data)
+			msg.Version = int(int32(version))
+		case num == requestFieldChannel && typ == protowire.BytesType:
+			msg.Channel, n = protowire.ConsumeString(data)
+		case num == requestFieldSymbol && typ == protowire.BytesType:
+			msg.Symbol, n = protowire.ConsumeString(data)
+		case num == requestFieldSymbols && typ == protowire.BytesType:
+			var symbol string
+			symbol, n = protowire.ConsumeString(data)
+			msg.Symbols = append(msg.Symbols, symbol)
+		case num == requestFieldEpoch && typ == protowire.BytesType:
+			msg.Epoch, n = protowire.ConsumeString(data)
+		case num == requestFieldLastSeq && typ == protowire.VarintType:
+			var seq uint64
+			seq, n = protowire.ConsumeVarint(data)
+			msg.LastSeq = &seq
+		case num == requestFieldMaxRate && typ == protowire.Fixed64Type:
+			var bits uint64
+			bits, n = protowire.ConsumeFixed64(data)
+			msg.MaxRate = math.Float64frombits(bits)
+		case num == requestFieldLastSeqs && typ == protowire.BytesType:
+			var entry []byte
+			if entry, n = protowire.ConsumeBytes(data); n >= 0 {
+				symbol, seq, err := unmarshalLastSeq(entry)
+				if err != nil {
+					return err
+				}
+				if msg.LastSeqs == nil {
+					msg.LastSeqs = make(map[string]uint64)
+				}
+				msg.LastSeqs[symbol] = seq
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// unmarshalLastSeq decodes one entry of the last_seqs map
+func unmarshalLastSeq(data []byte) (string, uint64, error) {
+	var symbol string
+	var seq uint64
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			symbol, n = protowire.ConsumeString(data)
+		case num == 2 && typ == protowire.VarintType:
+			seq, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+
+		if n < 0 {
+			return "", 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return symbol, seq, nil
+}
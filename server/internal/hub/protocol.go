@@ -0,0 +1,309 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// ProtocolVersion is the WebSocket protocol version this hub speaks. It is
+// announced in the welcome message; requests naming another version are
+// rejected.
+const ProtocolVersion = 1
+
+// Requests a client can send
+const (
+	requestSubscribe   = "subscribe"
+	requestUnsubscribe = "unsubscribe"
+	requestList        = "list"
+	requestPing        = "ping"
+)
+
+// Error codes sent in error replies
+const (
+	errInvalidMessage     = "invalid_message"
+	errUnsupportedVersion = "unsupported_version"
+	errUnknownType        = "unknown_type"
+	errUnknownChannel     = "unknown_channel"
+	errInvalidSymbol      = "invalid_symbol"
+	errInvalidRate        = "invalid_rate"
+	errTooManySymbols     = "too_many_symbols"
+	errSubscriptionLimit  = "subscription_limit"
+	errRateLimited        = "rate_limited"
+)
+
+const (
+	// Most symbols one request may name
+	maxBatchSymbols = 50
+
+	// Longest symbol accepted, enough for exchange-prefixed crypto pairs
+	maxSymbolLength = 20
+
+	// Subscriptions per client when none is configured, counting each
+	// channel of a symbol
+	defaultMaxSubscriptions = 50
+)
+
+// processMessage handles incoming messages from the client. Every request
+// is answered with an ack, a pong or an error echoing its ID.
+func (c *Client) processMessage(frameType int, message []byte) {
+	var msg models.SubscriptionMessage
+	if format, err := c.decodeRequest(frameType, message, &msg); err != nil {
+		log.Printf("Error parsing message from client %s: %v", c.id, err)
+		c.replyError(nil, errInvalidMessage, fmt.Sprintf("Message is not valid %s", format), http.StatusBadRequest)
+		return
+	}
+
+	if msg.Version != 0 && msg.Version != ProtocolVersion {
+		c.replyError(msg.ID, errUnsupportedVersion,
+			fmt.Sprintf("Protocol version %d is not supported, use %d", msg.Version, ProtocolVersion), http.StatusBadRequest)
+		return
+	}
+
+	switch msg.Type {
+	case requestSubscribe:
+		c.handleSubscribe(msg)
+
+	case requestUnsubscribe:
+		c.handleUnsubscribe(msg)
+
+	case requestList:
+		c.handleList(msg)
+
+	case requestPing:
+		c.reply(models.WebSocketMessage{
+			Type:      "pong",
+			ID:        msg.ID,
+			Timestamp: time.Now(),
+		})
+
+	default:
+		log.Printf("Unknown message type '%s' from client %s", msg.Type, c.id)
+		c.replyError(msg.ID, errUnknownType, fmt.Sprintf("Unknown message type %q", msg.Type), http.StatusBadRequest)
+	}
+}
+
+// handleSubscribe subscribes to every requested symbol on the requested
+// channel, or to none if that would take the client over its limit.
+// Symbols the client is already subscribed to get the requested rate.
+func (c *Client) handleSubscribe(msg models.SubscriptionMessage) {
+	channel, ok := c.requestedChannel(msg)
+	if !ok {
+		return
+	}
+	symbols, ok := c.requestedSymbols(msg)
+	if !ok {
+		return
+	}
+	if msg.MaxRate < 0 || math.IsNaN(msg.MaxRate) || math.IsInf(msg.MaxRate, 0) {
+		c.replyError(msg.ID, errInvalidRate, "maxRate must be a non-negative number of updates per second", http.StatusBadRequest)
+		return
+	}
+
+	current := make(map[topic]bool)
+	for _, t := range c.subscribedTopics() {
+		current[t] = true
+	}
+	newTopics := 0
+	for _, symbol := range symbols {
+		if !current[topic{channel, symbol}] {
+			newTopics++
+		}
+	}
+
+	limit := c.hub.maxSubscriptions
+	if len(current)+newTopics > limit {
+		c.replyError(msg.ID, errSubscriptionLimit,
+			fmt.Sprintf("Subscribing would exceed the limit of %d subscriptions", limit), http.StatusTooManyRequests)
+		return
+	}
+
+	interval := c.hub.subscriberInterval(channel, msg.MaxRate)
+	added := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		if c.hub.subscribeClient(c, channel, symbol, interval) {
+			added = append(added, symbol)
+		} else {
+			c.setInterval(topic{channel, symbol}, interval)
+		}
+	}
+	c.replyAck(msg.ID, requestSubscribe, channel, symbols, c.hub.grantedRate(channel, interval))
+
+	// Snapshots or replays follow the ack
+	for _, symbol := range added {
+		go c.hub.syncClient(c, topic{channel, symbol}, c.resumePoint(msg, symbol, len(symbols)))
+	}
+}
+
+// resumePoint returns where a subscribe request asks to resume symbol.
+// Sequence numbers from another epoch can't be resumed from.
+func (c *Client) resumePoint(msg models.SubscriptionMessage, symbol string, requested int) resumePoint {
+	if msg.Epoch != c.hub.epoch {
+		return resumePoint{}
+	}
+	for name, seq := range msg.LastSeqs {
+		if strings.ToUpper(strings.TrimSpace(name)) == symbol {
+			return resumePoint{seq: seq, ok: true}
+		}
+	}
+	if msg.LastSeq != nil && requested == 1 {
+		return resumePoint{seq: *msg.LastSeq, ok: true}
+	}
+	return resumePoint{}
+}
+
+// handleUnsubscribe drops every requested symbol from the requested
+// channel. Symbols the client wasn't subscribed to are ignored.
+func (c *Client) handleUnsubscribe(msg models.SubscriptionMessage) {
+	channel, ok := c.requestedChannel(msg)
+	if !ok {
+		return
+	}
+	symbols, ok := c.requestedSymbols(msg)
+	if !ok {
+		return
+	}
+
+	for _, symbol := range symbols {
+		c.hub.unsubscribeClient(c, channel, symbol)
+	}
+	c.replyAck(msg.ID, requestUnsubscribe, channel, symbols, 0)
+}
+
+// handleList reports every subscription, grouped by channel
+func (c *Client) handleList(msg models.SubscriptionMessage) {
+	topics := c.subscribedTopics()
+
+	channels := make(map[string][]string)
+	seen := make(map[string]bool)
+	symbols := make([]string, 0)
+	for _, t := range topics {
+		channels[string(t.channel)] = append(channels[string(t.channel)], t.symbol)
+		if !seen[t.symbol] {
+			seen[t.symbol] = true
+			symbols = append(symbols, t.symbol)
+		}
+	}
+	for _, list := range channels {
+		sort.Strings(list)
+	}
+	sort.Strings(symbols)
+
+	c.reply(models.WebSocketMessage{
+		Type: "ack",
+		ID:   msg.ID,
+		Data: models.SubscriptionAck{
+			Request:       requestList,
+			Symbols:       symbols,
+			Channels:      channels,
+			Subscriptions: len(topics),
+			Limit:         c.hub.maxSubscriptions,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// requestedChannel returns the channel a request names, quotes if it names
+// none, replying with an error and returning false if it is unknown
+func (c *Client) requestedChannel(msg models.SubscriptionMessage) (Channel, bool) {
+	if msg.Channel == "" {
+		return ChannelQuotes, true
+	}
+
+	channel, ok := ParseChannel(msg.Channel)
+	if !ok {
+		c.replyError(msg.ID, errUnknownChannel, fmt.Sprintf("Unknown channel %q", msg.Channel), http.StatusBadRequest)
+		return "", false
+	}
+	return channel, true
+}
+
+// requestedSymbols validates and normalizes the symbols a request names,
+// replying with an error and returning false if any is unusable
+func (c *Client) requestedSymbols(msg models.SubscriptionMessage) ([]string, bool) {
+	requested := msg.Symbols
+	if msg.Symbol != "" {
+		requested = append([]string{msg.Symbol}, requested...)
+	}
+
+	if len(requested) == 0 {
+		c.replyError(msg.ID, errInvalidSymbol, "symbol or symbols is required", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(requested) > maxBatchSymbols {
+		c.replyError(msg.ID, errTooManySymbols,
+			fmt.Sprintf("Maximum %d symbols allowed per request", maxBatchSymbols), http.StatusBadRequest)
+		return nil, false
+	}
+
+	seen := make(map[string]bool, len(requested))
+	symbols := make([]string, 0, len(requested))
+	for _, symbol := range requested {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if !validSymbol(symbol) {
+			c.replyError(msg.ID, errInvalidSymbol, fmt.Sprintf("Invalid symbol %q", symbol), http.StatusBadRequest)
+			return nil, false
+		}
+		if !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols, true
+}
+
+// validSymbol accepts tickers like AAPL, BRK.B, ^GSPC and BINANCE:BTCUSDT
+func validSymbol(symbol string) bool {
+	if symbol == "" || len(symbol) > maxSymbolLength {
+		return false
+	}
+	for _, r := range symbol {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(".-:^=_/", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) replyAck(id interface{}, request string, channel Channel, symbols []string, maxRate float64) {
+	c.reply(models.WebSocketMessage{
+		Type: "ack",
+		ID:   id,
+		Data: models.SubscriptionAck{
+			Request:       request,
+			Channel:       string(channel),
+			Symbols:       symbols,
+			MaxRate:       maxRate,
+			Subscriptions: len(c.subscribedTopics()),
+			Limit:         c.hub.maxSubscriptions,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+func (c *Client) replyError(id interface{}, code, message string, status int) {
+	c.reply(models.WebSocketMessage{
+		Type: "error",
+		ID:   id,
+		Data: models.ErrorResponse{
+			Error:   code,
+			Message: message,
+			Code:    status,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// reply queues a message for the client
+func (c *Client) reply(msg models.WebSocketMessage) {
+	c.trySend(newWireMessage(msg))
+}
@@ -0,0 +1,236 @@
+package hub
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SlowClientPolicy decides what happens to updates for a client whose send
+// queue is full because it reads slower than the hub publishes
+type SlowClientPolicy string
+
+const (
+	// SlowClientConflate merges a newer quote into one still queued for
+	// the same symbol instead of queueing both, and drops the oldest queued
+	// update when the queue is full anyway
+	SlowClientConflate SlowClientPolicy = "conflate"
+
+	// SlowClientDropOldest drops the oldest queued update to make room
+	SlowClientDropOldest SlowClientPolicy = "drop_oldest"
+
+	// SlowClientDisconnect drops new updates while the queue is full and
+	// disconnects the client if it stays full for longer than allowed
+	SlowClientDisconnect SlowClientPolicy = "disconnect"
+)
+
+const (
+	// Messages queued per client before the slow client policy applies
+	sendQueueSize = 256
+
+	// How long a client's queue may stay full under SlowClientDisconnect
+	// when nothing else is configured
+	defaultDisconnectAfter = 5 * time.Second
+)
+
+// ParseSlowClientPolicy validates a slow client policy name
+func ParseSlowClientPolicy(name string) (SlowClientPolicy, error) {
+	switch policy := SlowClientPolicy(name); policy {
+	case SlowClientConflate, SlowClientDropOldest, SlowClientDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow client policy %q", name)
+	}
+}
+
+// SetSlowClientPolicy sets how clients that fall behind are handled.
+// disconnectAfter only applies to SlowClientDisconnect; zero disconnects as
+// soon as the queue is full. It must be called before Run.
+func (h *Hub) SetSlowClientPolicy(policy SlowClientPolicy, disconnectAfter time.Duration) {
+	h.slowClientPolicy = policy
+	if disconnectAfter >= 0 {
+		h.disconnectAfter = disconnectAfter
+	}
+}
+
+// outbound is a message waiting for writePump. Sequenced updates may be
+// dropped or conflated when the client falls behind, since the gap in
+// sequence numbers tells the client; anything else, such as replies and
+// snapshots, never is.
+type outbound struct {
+	msg *wireMessage
+
+	// Topic of an update or snapshot
+	topic  topic
+	update bool
+}
+
+// conflatable reports whether a newer update on the same topic makes this
+// one redundant
+func (m outbound) conflatable() bool {
+	return m.update && channelSpecs[m.topic.channel].conflate != nil
+}
+
+// ClientStats describes a connected client and how far behind it is
+type ClientStats struct {
+	ID            string    `json:"id"`
+	IPAddress     string    `json:"ipAddress"`
+	UserAgent     string    `json:"userAgent"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	Subscriptions int       `json:"subscriptions"`
+
+	// Messages waiting to be written, and since when the queue has been
+	// full
+	Queued      int        `json:"queued"`
+	BehindSince *time.Time `json:"behindSince,omitempty"`
+
+	// Updates dropped, and quotes merged into queued ones, because the
+	// client fell behind
+	Dropped   int64 `json:"dropped"`
+	Conflated int64 `json:"conflated"`
+}
+
+// ClientStats returns every connected client, most dropped messages first
+func (h *Hub) ClientStats() []ClientStats {
+	reply := make(chan []*Client, 1)
+	select {
+	case h.listClients <- reply:
+	case <-h.ctx.Done():
+		return []ClientStats{}
+	}
+	connected := <-reply
+
+	stats := make([]ClientStats, 0, len(connected))
+	for _, client := range connected {
+		stats = append(stats, client.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Dropped != stats[j].Dropped {
+			return stats[i].Dropped > stats[j].Dropped
+		}
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+func (c *Client) stats() ClientStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := ClientStats{
+		ID:            c.id,
+		IPAddress:     c.ipAddress,
+		UserAgent:     c.userAgent,
+		ConnectedAt:   c.connectedAt,
+		Subscriptions: len(c.subscriptions),
+		Queued:        len(c.queue),
+		Dropped:       c.dropped,
+		Conflated:     c.conflated,
+	}
+	if !c.behindSince.IsZero() {
+		behindSince := c.behindSince
+		stats.BehindSince = &behindSince
+	}
+	return stats
+}
+
+// enqueue hands a message to writePump, applying the hub's slow client
+// policy if the queue is full. It returns false if the client is closed,
+// including when the policy just closed it. The caller holds c.mutex.
+func (c *Client) enqueue(msg outbound) bool {
+	if c.closed {
+		return false
+	}
+
+	policy := c.hub.slowClientPolicy
+	if policy == SlowClientConflate && msg.conflatable() {
+		// Fold into the newest queued message on the topic if it is an
+		// update, which keeps the topic's messages in order
+		for i := len(c.queue) - 1; i >= 0; i-- {
+			if c.queue[i].topic != msg.topic {
+				continue
+			}
+			if c.queue[i].update {
+				queued := c.queue[i].msg.message
+				update := channelSpecs[msg.topic.channel].conflate(queued.Data, msg.msg.message.Data)
+				msg.msg = c.hub.channelMessage(msg.topic, update, msg.msg.message.Seq, false)
+				c.queue[i] = msg
+				c.conflated++
+				return true
+			}
+			break
+		}
+	}
+
+	if len(c.queue) >= sendQueueSize {
+		now := time.Now()
+		if c.behindSince.IsZero() {
+			c.behindSince = now
+		}
+
+		switch {
+		case policy == SlowClientDisconnect && now.Sub(c.behindSince) >= c.hub.disconnectAfter:
+			c.closeLocked()
+			return false
+
+		case policy == SlowClientDisconnect && msg.update:
+			c.dropUpdate(msg.topic)
+			return true
+
+		case !c.dropOldest():
+			if msg.update {
+				c.dropUpdate(msg.topic)
+				return true
+			}
+			// Nothing can make room for a message that must not be lost
+			c.closeLocked()
+			return false
+		}
+	}
+
+	c.queue = append(c.queue, msg)
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dropOldest removes the oldest queued update, returning false if only
+// messages that must not be dropped are queued. The caller holds c.mutex.
+func (c *Client) dropOldest() bool {
+	for i, queued := range c.queue {
+		if queued.update {
+			copy(c.queue[i:], c.queue[i+1:])
+			c.queue = c.queue[:len(c.queue)-1]
+			c.dropUpdate(queued.topic)
+			return true
+		}
+	}
+	return false
+}
+
+// dropUpdate counts an update on t that was dropped. The client's next
+// quote on t is sent whole, since the dropped one may have carried changes
+// the client never sees otherwise. The caller holds c.mutex.
+func (c *Client) dropUpdate(t topic) {
+	c.dropped++
+	if sub := c.subscriptions[t]; sub != nil {
+		sub.quote = nil
+	}
+}
+
+// takeQueued removes and returns every queued message, which means the
+// client has caught up
+func (c *Client) takeQueued() []*wireMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := make([]*wireMessage, len(c.queue))
+	for i, queued := range c.queue {
+		messages[i] = queued.msg
+	}
+	c.queue = c.queue[:0]
+	c.behindSince = time.Time{}
+	return messages
+}
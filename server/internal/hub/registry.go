@@ -0,0 +1,223 @@
+package hub
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// registryShards spreads symbols over this many independently locked
+// shards so subscription changes and fan-out for different symbols don't
+// contend
+const registryShards = 64
+
+// topic is one channel of one symbol, the unit clients subscribe to
+type topic struct {
+	channel Channel
+	symbol  string
+}
+
+// registry maps topics to their subscribed clients. It is safe for
+// concurrent use: each shard has its own lock, and a client's own set of
+// topics is guarded by the client. Every channel of a symbol lives in the
+// same shard. Locks are always taken shard first, then client.
+type registry struct {
+	shards [registryShards]registryShard
+}
+
+type registryShard struct {
+	mutex sync.RWMutex
+
+	// symbol -> channel -> subscribers
+	symbols map[string]map[Channel]map[*Client]struct{}
+}
+
+func newRegistry() *registry {
+	r := &registry{}
+	for i := range r.shards {
+		r.shards[i].symbols = make(map[string]map[Channel]map[*Client]struct{})
+	}
+	return r
+}
+
+func (r *registry) shard(symbol string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return &r.shards[h.Sum32()%registryShards]
+}
+
+// subscribe adds client to t with the given update interval. When t gains
+// its first subscriber, onChange runs under the shard and client locks with
+// the symbol's channels that now have subscribers, so it is ordered with
+// other changes to the symbol; it must not take a topic state's lock. It
+// returns false if the client was already subscribed or has disconnected.
+func (r *registry) subscribe(client *Client, t topic, interval time.Duration, onChange func(active []Channel)) bool {
+	shard := r.shard(t.symbol)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if _, ok := client.subscriptions[t]; ok || client.closed {
+		return false
+	}
+
+	channels := shard.symbols[t.symbol]
+	if channels == nil {
+		channels = make(map[Channel]map[*Client]struct{})
+		shard.symbols[t.symbol] = channels
+	}
+
+	subscribers := channels[t.channel]
+	if subscribers == nil {
+		subscribers = make(map[*Client]struct{})
+		channels[t.channel] = subscribers
+		if onChange != nil {
+			onChange(activeChannels(channels))
+		}
+	}
+
+	// The client gets updates once it has been synced
+	subscribers[client] = struct{}{}
+	client.subscriptions[t] = &subscription{interval: interval}
+	return true
+}
+
+// unsubscribe removes client from t. When t has no subscribers left,
+// onChange runs under the shard lock with the symbol's remaining channels,
+// with the same restrictions as for subscribe. It returns false if the
+// client wasn't subscribed.
+func (r *registry) unsubscribe(client *Client, t topic, onChange func(active []Channel)) bool {
+	shard := r.shard(t.symbol)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	channels := shard.symbols[t.symbol]
+	subscribers, ok := channels[t.channel]
+	if !ok {
+		return false
+	}
+	if _, ok := subscribers[client]; !ok {
+		return false
+	}
+
+	delete(subscribers, client)
+	client.mutex.Lock()
+	if sub := client.subscriptions[t]; sub != nil && sub.timer != nil {
+		sub.timer.Stop()
+	}
+	delete(client.subscriptions, t)
+	client.mutex.Unlock()
+
+	if len(subscribers) == 0 {
+		delete(channels, t.channel)
+		if len(channels) == 0 {
+			delete(shard.symbols, t.symbol)
+		}
+		if onChange != nil {
+			onChange(activeChannels(channels))
+		}
+	}
+	return true
+}
+
+func activeChannels(channels map[Channel]map[*Client]struct{}) []Channel {
+	active := make([]Channel, 0, len(channels))
+	for channel := range channels {
+		active = append(active, channel)
+	}
+	return active
+}
+
+// subscribers returns a snapshot of the clients subscribed to t
+func (r *registry) subscribers(t topic) []*Client {
+	shard := r.shard(t.symbol)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	subscribers := shard.symbols[t.symbol][t.channel]
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	clients := make([]*Client, 0, len(subscribers))
+	for client := range subscribers {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// subscribed reports whether t has any subscribers
+func (r *registry) subscribed(t topic) bool {
+	shard := r.shard(t.symbol)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	return len(shard.symbols[t.symbol][t.channel]) > 0
+}
+
+// symbols returns every symbol with at least one subscriber on any of
+// channels, or on any channel at all if none are given
+func (r *registry) symbols(channels ...Channel) []string {
+	symbols := make([]string, 0)
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mutex.RLock()
+		for symbol, subscribed := range shard.symbols {
+			if len(channels) == 0 {
+				symbols = append(symbols, symbol)
+				continue
+			}
+			for _, channel := range channels {
+				if _, ok := subscribed[channel]; ok {
+					symbols = append(symbols, symbol)
+					break
+				}
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return symbols
+}
+
+// top returns up to limit symbols with the most subscriptions across all
+// channels, most subscribed first. A limit of zero returns them all.
+func (r *registry) top(limit int) []string {
+	type symbolCount struct {
+		symbol string
+		count  int
+	}
+
+	counts := make([]symbolCount, 0)
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mutex.RLock()
+		for symbol, channels := range shard.symbols {
+			count := 0
+			for _, subscribers := range channels {
+				count += len(subscribers)
+			}
+			counts = append(counts, symbolCount{symbol, count})
+		}
+		shard.mutex.RUnlock()
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].symbol < counts[j].symbol
+	})
+
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+
+	symbols := make([]string, len(counts))
+	for i, c := range counts {
+		symbols[i] = c.symbol
+	}
+	return symbols
+}
@@ -0,0 +1,189 @@
+package hub
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(h *Hub, id string) *Client {
+	return &Client{
+		hub:           h,
+		id:            id,
+		ready:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		subscriptions: make(map[topic]*subscription),
+	}
+}
+
+func sortedChannels(channels []Channel) []Channel {
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	return channels
+}
+
+func TestRegistrySubscribeUnsubscribe(t *testing.T) {
+	r := newRegistry()
+	a := newTestClient(nil, "a")
+	b := newTestClient(nil, "b")
+	quotes := topic{ChannelQuotes, "AAPL"}
+	trades := topic{ChannelTrades, "AAPL"}
+
+	var changes [][]Channel
+	onChange := func(active []Channel) {
+		changes = append(changes, sortedChannels(active))
+	}
+
+	if !r.subscribe(a, quotes, 0, onChange) {
+		t.Fatal("first subscribe returned false")
+	}
+	if r.subscribe(a, quotes, 0, onChange) {
+		t.Fatal("duplicate subscribe returned true")
+	}
+	if !r.subscribe(b, quotes, 0, onChange) {
+		t.Fatal("second client's subscribe returned false")
+	}
+	if !r.subscribe(b, trades, 0, onChange) {
+		t.Fatal("subscribe to another channel returned false")
+	}
+
+	// Only a topic's first subscriber changes the active channels
+	want := [][]Channel{{ChannelQuotes}, {ChannelQuotes, ChannelTrades}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+
+	if got := len(r.subscribers(quotes)); got != 2 {
+		t.Fatalf("quotes has %d subscribers, want 2", got)
+	}
+	if got := r.symbols(ChannelNews); len(got) != 0 {
+		t.Fatalf("symbols(news) = %v, want none", got)
+	}
+	if got := r.symbols(ChannelTrades); !reflect.DeepEqual(got, []string{"AAPL"}) {
+		t.Fatalf("symbols(trades) = %v, want [AAPL]", got)
+	}
+
+	changes = nil
+	if r.unsubscribe(a, trades, onChange) {
+		t.Fatal("unsubscribe from a topic the client isn't on returned true")
+	}
+	if !r.unsubscribe(a, quotes, onChange) {
+		t.Fatal("unsubscribe returned false")
+	}
+	if !r.subscribed(quotes) {
+		t.Fatal("quotes lost its remaining subscriber")
+	}
+	if !r.unsubscribe(b, quotes, onChange) {
+		t.Fatal("unsubscribe of the last subscriber returned false")
+	}
+	if r.subscribed(quotes) {
+		t.Fatal("quotes still subscribed after everyone left")
+	}
+
+	want = [][]Channel{{ChannelTrades}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	if _, ok := a.subscriptions[quotes]; ok {
+		t.Fatal("client still records its subscription")
+	}
+}
+
+func TestRegistrySubscribeClosedClient(t *testing.T) {
+	r := newRegistry()
+	c := newTestClient(nil, "closed")
+	c.close()
+
+	if r.subscribe(c, topic{ChannelQuotes, "AAPL"}, 0, nil) {
+		t.Fatal("subscribe of a closed client returned true")
+	}
+	if got := r.symbols(); len(got) != 0 {
+		t.Fatalf("symbols() = %v, want none", got)
+	}
+}
+
+func TestRegistryTop(t *testing.T) {
+	r := newRegistry()
+	subscribe := func(symbol string, count int) {
+		for i := 0; i < count; i++ {
+			c := newTestClient(nil, fmt.Sprintf("%s-%d", symbol, i))
+			r.subscribe(c, topic{ChannelQuotes, symbol}, 0, nil)
+		}
+	}
+	subscribe("MSFT", 1)
+	subscribe("AAPL", 3)
+	subscribe("TSLA", 2)
+	subscribe("AMZN", 1)
+
+	if got, want := r.top(0), []string{"AAPL", "TSLA", "AMZN", "MSFT"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("top(0) = %v, want %v", got, want)
+	}
+	if got, want := r.top(2), []string{"AAPL", "TSLA"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("top(2) = %v, want %v", got, want)
+	}
+}
+
+// TestRegistryConcurrent churns subscriptions from many goroutines while
+// others read, for go test -race
+func TestRegistryConcurrent(t *testing.T) {
+	r := newRegistry()
+	clients := make([]*Client, 50)
+	for i := range clients {
+		clients[i] = newTestClient(nil, fmt.Sprint(i))
+	}
+	symbols := []string{"AAPL", "MSFT", "TSLA", "AMZN", "GOOGL", "META", "NVDA", "NFLX"}
+	channels := []Channel{ChannelQuotes, ChannelTrades, ChannelCandles}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(100 * time.Microsecond):
+				}
+				for _, symbol := range symbols {
+					r.subscribers(topic{ChannelQuotes, symbol})
+				}
+				r.symbols(ChannelTrades)
+				r.top(3)
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for i, c := range clients {
+		writers.Add(1)
+		go func(i int, c *Client) {
+			defer writers.Done()
+			for n := 0; n < 200; n++ {
+				tp := topic{channels[(i+n)%len(channels)], symbols[(i*7+n)%len(symbols)]}
+				r.subscribe(c, tp, 0, nil)
+				if n%3 != 0 {
+					r.unsubscribe(c, tp, nil)
+				}
+			}
+			for _, tp := range c.subscribedTopics() {
+				r.unsubscribe(c, tp, nil)
+			}
+		}(i, c)
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if got := r.symbols(); len(got) != 0 {
+		t.Fatalf("symbols() = %v after everyone left, want none", got)
+	}
+	for _, c := range clients {
+		if got := c.subscribedTopics(); len(got) != 0 {
+			t.Fatalf("client %s still has %v", c.id, got)
+		}
+	}
+}
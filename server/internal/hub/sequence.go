@@ -0,0 +1,320 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// Replay defaults: messages kept per topic for clients resuming after a
+// reconnect, and how long a topic keeps them after its last subscriber
+// leaves
+const (
+	defaultReplaySize      = 100
+	defaultReplayRetention = 30 * time.Second
+)
+
+// topicState numbers the messages sent on a topic and keeps the most recent
+// ones for replay. Its lock orders snapshots with updates, so a client sees
+// its snapshot before any update that follows it.
+//
+// Locks are taken in the order topics map, topic state, registry shard,
+// client: the state's lock may be held while sending to clients, but never
+// taken while holding a shard or client lock.
+type topicState struct {
+	mutex sync.Mutex
+
+	// Sequence number of the last message sent on the topic
+	seq uint64
+
+	// Ring of the most recent messages, oldest at start
+	replay []sequencedMessage
+	start  int
+
+	// When the last subscriber left, zero while there are subscribers.
+	// Updates are still numbered and kept until the retention runs out.
+	idleSince time.Time
+
+	// Set once the state is dropped from the hub's topics
+	removed bool
+}
+
+type sequencedMessage struct {
+	seq uint64
+	msg *wireMessage
+}
+
+// resumePoint is where a client asks to pick a topic back up
+type resumePoint struct {
+	seq uint64
+	ok  bool
+}
+
+// SetReplay sets how many messages each topic keeps for clients that
+// resume after a reconnect, and for how long after its last subscriber
+// leaves. It must be called before Run.
+func (h *Hub) SetReplay(size int, retention time.Duration) {
+	if size > 0 {
+		h.replaySize = size
+	}
+	if retention > 0 {
+		h.replayRetention = retention
+	}
+}
+
+// newEpoch identifies a hub's sequence numbers. They restart with the
+// process, so a client can only resume with sequence numbers from the same
+// epoch.
+func newEpoch() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// topic returns the state for t, creating it on first use. A new state
+// numbers past every removed one, so a topic's sequence numbers never
+// repeat even if it is removed and comes back.
+func (h *Hub) topic(t topic) *topicState {
+	h.topicsMutex.Lock()
+	defer h.topicsMutex.Unlock()
+
+	state := h.topics[t]
+	if state == nil {
+		// Numbering starts at 1 so even the first snapshot carries a seq
+		state = &topicState{seq: h.retiredSeq + 1}
+		h.topics[t] = state
+	}
+	return state
+}
+
+// lockTopic returns the state for t with its lock held, never one that was
+// removed while waiting for the lock
+func (h *Hub) lockTopic(t topic) *topicState {
+	for {
+		state := h.topic(t)
+		state.mutex.Lock()
+		if !state.removed {
+			return state
+		}
+		state.mutex.Unlock()
+	}
+}
+
+// hasTopic reports whether t has state, which it keeps until it has been
+// idle for longer than the replay retention
+func (h *Hub) hasTopic(t topic) bool {
+	h.topicsMutex.Lock()
+	defer h.topicsMutex.Unlock()
+	return h.topics[t] != nil
+}
+
+// refreshTopic records whether t has subscribers after a subscription
+// change. It reads the registry under the state's lock, so of several
+// concurrent changes the last to refresh leaves the state right.
+func (h *Hub) refreshTopic(t topic) {
+	state := h.lockTopic(t)
+	defer state.mutex.Unlock()
+
+	switch {
+	case h.subscriptions.subscribed(t):
+		state.idleSince = time.Time{}
+	case state.idleSince.IsZero():
+		state.idleSince = time.Now()
+	}
+}
+
+// expireTopics removes topics idle for longer than the retention, so the
+// hub doesn't keep state for every symbol ever subscribed
+func (h *Hub) expireTopics() {
+	ticker := time.NewTicker(h.replayRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.removeIdleTopics(time.Now())
+
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) removeIdleTopics(now time.Time) {
+	h.topicsMutex.Lock()
+	states := make(map[topic]*topicState, len(h.topics))
+	for t, state := range h.topics {
+		states[t] = state
+	}
+	h.topicsMutex.Unlock()
+
+	for t, state := range states {
+		state.mutex.Lock()
+		idle := state.expire(now, h.replayRetention)
+		state.mutex.Unlock()
+		if !idle {
+			continue
+		}
+
+		// Check again under both locks, since a subscriber may have
+		// arrived in between
+		h.topicsMutex.Lock()
+		state.mutex.Lock()
+		if state.expire(now, h.replayRetention) && h.topics[t] == state {
+			state.removed = true
+			delete(h.topics, t)
+			if state.seq > h.retiredSeq {
+				h.retiredSeq = state.seq
+			}
+		}
+		state.mutex.Unlock()
+		h.topicsMutex.Unlock()
+	}
+}
+
+// expire forgets the replay buffer once the topic has been idle for longer
+// than retention. Skipping a sequence number makes any client resuming from
+// before this take a snapshot. The caller holds the state's lock.
+func (s *topicState) expire(now time.Time, retention time.Duration) bool {
+	if s.idleSince.IsZero() || now.Sub(s.idleSince) < retention {
+		return false
+	}
+	if s.replay != nil {
+		s.seq++
+		s.replay = nil
+		s.start = 0
+	}
+	return true
+}
+
+// record numbers a message and keeps it for replay. The caller holds the
+// state's lock.
+func (s *topicState) record(message func(seq uint64) *wireMessage, size int) *wireMessage {
+	s.seq++
+	msg := message(s.seq)
+
+	kept := sequencedMessage{seq: s.seq, msg: msg}
+	if len(s.replay) < size {
+		s.replay = append(s.replay, kept)
+	} else {
+		s.replay[s.start] = kept
+		s.start = (s.start + 1) % len(s.replay)
+	}
+	return msg
+}
+
+// since returns the messages after seq, or false if some of them are no
+// longer kept. The caller holds the state's lock.
+func (s *topicState) since(seq uint64) ([]*wireMessage, bool) {
+	if seq > s.seq {
+		return nil, false
+	}
+	if seq == s.seq {
+		return nil, true
+	}
+
+	missed := make([]*wireMessage, 0, s.seq-seq)
+	for i := range s.replay {
+		msg := s.replay[(s.start+i)%len(s.replay)]
+		if msg.seq <= seq {
+			continue
+		}
+		if len(missed) == 0 && msg.seq != seq+1 {
+			return nil, false
+		}
+		missed = append(missed, msg.msg)
+	}
+	return missed, len(missed) > 0
+}
+
+// syncClient brings a client that just subscribed to t up to date: it
+// replays what the client missed since resume, or sends a snapshot if that
+// isn't possible. Updates reach the client only after this.
+func (h *Hub) syncClient(client *Client, t topic, resume resumePoint) {
+	h.prepareSnapshot(t)
+
+	state := h.lockTopic(t)
+	defer state.mutex.Unlock()
+
+	if resume.ok {
+		if missed, ok := state.since(resume.seq); ok {
+			for _, msg := range missed {
+				if !client.sendMissed(t, msg) {
+					return
+				}
+			}
+			client.markSynced(t)
+			return
+		}
+	}
+
+	if !client.sendSnapshot(t, h.channelMessage(t, h.snapshot(t), state.seq, true)) {
+		return
+	}
+	client.markSynced(t)
+}
+
+// prepareSnapshot fetches what a snapshot of t needs if the hub doesn't
+// have it yet. Fetched data is also published to existing subscribers.
+func (h *Hub) prepareSnapshot(t topic) {
+	switch t.channel {
+	case ChannelQuotes:
+		h.quotesMutex.RLock()
+		quote := h.latestQuotes[t.symbol]
+		h.quotesMutex.RUnlock()
+
+		if quote == nil {
+			if quote, err := h.provider.GetQuote(h.ctx, t.symbol); err == nil {
+				h.handleQuote(quote)
+			}
+		}
+
+	case ChannelOrderBook:
+		h.booksMutex.Lock()
+		book := h.orderBooks[t.symbol]
+		h.booksMutex.Unlock()
+
+		if book == nil {
+			fetchedAt := time.Now()
+			if book, err := h.provider.GetOrderBook(h.ctx, t.symbol); err == nil {
+				h.updateOrderBook(t.symbol, book, fetchedAt)
+			}
+		}
+	}
+}
+
+// snapshot returns the current state of t, shaped like the channel's
+// updates. Trades and news have no state to snapshot, so theirs are empty.
+func (h *Hub) snapshot(t topic) interface{} {
+	switch t.channel {
+	case ChannelQuotes:
+		h.quotesMutex.RLock()
+		defer h.quotesMutex.RUnlock()
+		return h.latestQuotes[t.symbol]
+
+	case ChannelOrderBook:
+		h.booksMutex.Lock()
+		defer h.booksMutex.Unlock()
+		if book := h.orderBooks[t.symbol]; book != nil {
+			return orderBookUpdate(t.symbol, book)
+		}
+		return nil
+
+	case ChannelCandles:
+		h.barsMutex.Lock()
+		defer h.barsMutex.Unlock()
+		if bar := h.bars[t.symbol]; bar != nil {
+			return []models.Bar{*bar}
+		}
+		return []models.Bar{}
+
+	case ChannelTrades:
+		return []models.Trade{}
+
+	default:
+		return []models.NewsItem{}
+	}
+}
@@ -0,0 +1,268 @@
+package hub
+
+import (
+	"time"
+
+	"equity-server/internal/models"
+)
+
+// subscription is one client's subscription to a topic. It is guarded by
+// the client's mutex.
+type subscription struct {
+	// Set once the client has its snapshot and is getting updates
+	synced bool
+
+	// Least time between updates, zero sending every flush
+	interval time.Duration
+	lastSent time.Time
+
+	// Updates that came too soon after the last one, merged, and the
+	// timer that sends them once the interval is up
+	held    interface{}
+	heldSeq uint64
+	timer   *time.Timer
+
+	// Last quote the client was sent, the base for the next delta. Nil
+	// sends the next quote whole.
+	quote *models.Quote
+}
+
+// subscriberInterval returns the least time between updates on channel
+// for a subscribe request asking for maxRate updates per second, or for
+// the channel's default if it names none. Updates are never sent more
+// often than the channel is flushed, so any interval shorter than that
+// is zero.
+func (h *Hub) subscriberInterval(channel Channel, maxRate float64) time.Duration {
+	interval := channelSpecs[channel].subscriberInterval
+	if maxRate > 0 {
+		interval = time.Duration(float64(time.Second) / maxRate)
+	}
+	if interval <= h.channels[channel].interval {
+		return 0
+	}
+	return interval
+}
+
+// grantedRate is the most updates per second a subscription on channel
+// with interval gets
+func (h *Hub) grantedRate(channel Channel, interval time.Duration) float64 {
+	if flush := h.channels[channel].interval; interval < flush {
+		interval = flush
+	}
+	return 1 / interval.Seconds()
+}
+
+// quoteDeltas holds the delta messages of one quote broadcast by the base
+// quote they apply to. Subscribers in step with the feed all have the
+// previous broadcast as their base, so they share one message, encoded
+// once per format, instead of each getting their own.
+type quoteDeltas map[*models.Quote]*wireMessage
+
+// setInterval changes how often an existing subscription gets updates
+func (c *Client) setInterval(t topic, interval time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if sub := c.subscriptions[t]; sub != nil {
+		sub.interval = interval
+	}
+}
+
+// sendUpdate delivers an update on t, skipping it if the client hasn't
+// been synced to t yet. An update that comes sooner than the
+// subscription's interval allows is held and merged with any that follow
+// until the interval is up. deltas collects the quote deltas of the
+// broadcast msg belongs to. It returns false if the client is closed.
+func (c *Client) sendUpdate(t topic, msg *wireMessage, deltas quoteDeltas) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+	sub := c.subscriptions[t]
+	if sub == nil || !sub.synced {
+		return true
+	}
+
+	now := time.Now()
+	update, seq := msg.message.Data, msg.message.Seq
+	merge := channelSpecs[t.channel].merge
+
+	if wait := sub.interval - now.Sub(sub.lastSent); wait > 0 {
+		sub.held = merge(sub.held, update)
+		sub.heldSeq = seq
+		if sub.timer == nil {
+			sub.timer = time.AfterFunc(wait, func() { c.releaseHeld(t, sub) })
+		}
+		return true
+	}
+
+	if sub.held != nil {
+		// The merged update is the client's own
+		update = merge(sub.held, update)
+		msg = nil
+		deltas = nil
+		sub.held = nil
+		sub.timer.Stop()
+		sub.timer = nil
+	}
+	return c.deliver(t, sub, update, seq, msg, deltas, now)
+}
+
+// releaseHeld sends the updates held back on a subscription once its
+// interval is up
+func (c *Client) releaseHeld(t topic, sub *subscription) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sub.timer = nil
+	if c.closed || c.subscriptions[t] != sub || sub.held == nil {
+		return
+	}
+
+	update := sub.held
+	sub.held = nil
+	c.deliver(t, sub, update, sub.heldSeq, nil, nil, time.Now())
+}
+
+// deliver queues an update on t. Quotes are sent as a delta against the
+// last quote the client got, and not at all if nothing changed, which
+// leaves a gap in seq the client can ignore. shared is
+// the message for update if it can be sent as is, nil to build one, and
+// deltas the deltas already built for other clients, if any. The caller
+// holds c.mutex.
+func (c *Client) deliver(t topic, sub *subscription, update interface{}, seq uint64, shared *wireMessage, deltas quoteDeltas, now time.Time) bool {
+	msg := shared
+	if quote, ok := update.(*models.Quote); ok {
+		var built bool
+		if msg, built = deltas[sub.quote]; !built {
+			if delta, changed := quoteDelta(sub.quote, quote); changed {
+				msg = c.hub.channelMessage(t, delta, seq, false)
+			}
+			if deltas != nil {
+				deltas[sub.quote] = msg
+			}
+		}
+		if msg == nil {
+			return true
+		}
+		sub.quote = quote
+	} else if msg == nil {
+		msg = c.hub.channelMessage(t, update, seq, false)
+	}
+
+	sub.lastSent = now
+	return c.enqueue(outbound{msg: msg, topic: t, update: true})
+}
+
+// quoteDelta returns the fields of next that differ from prev, and whether
+// any did. A new timestamp alone is not a change. Without a previous quote
+// every field is set.
+func quoteDelta(prev, next *models.Quote) (models.QuoteDelta, bool) {
+	if prev == nil {
+		prev = &models.Quote{}
+	}
+	all := prev.Symbol == ""
+
+	delta := models.QuoteDelta{Symbol: next.Symbol}
+	changed := false
+	diff := func(field **float64, before, after float64) {
+		if all || before != after {
+			*field = &after
+			changed = true
+		}
+	}
+	diff(&delta.CurrentPrice, prev.CurrentPrice, next.CurrentPrice)
+	diff(&delta.Change, prev.Change, next.Change)
+	diff(&delta.PercentChange, prev.PercentChange, next.PercentChange)
+	diff(&delta.High, prev.High, next.High)
+	diff(&delta.Low, prev.Low, next.Low)
+	diff(&delta.Open, prev.Open, next.Open)
+	diff(&delta.PreviousClose, prev.PreviousClose, next.PreviousClose)
+
+	if all || prev.Provider != next.Provider {
+		provider := next.Provider
+		delta.Provider = &provider
+		changed = true
+	}
+	if all || prev.Stale != next.Stale {
+		stale := next.Stale
+		delta.Stale = &stale
+		changed = true
+	}
+	if changed {
+		timestamp := next.Timestamp
+		delta.Timestamp = &timestamp
+	}
+	return delta, changed
+}
+
+// conflateQuotes folds a quote update into one still queued: a delta on
+// top of a delta keeps both deltas' fields, and anything on top of a full
+// quote is applied to it
+func conflateQuotes(queued, update interface{}) interface{} {
+	next, ok := update.(models.QuoteDelta)
+	if !ok {
+		return update
+	}
+
+	switch queued := queued.(type) {
+	case *models.Quote:
+		quote := *queued
+		applyQuoteDelta(&quote, next)
+		return &quote
+
+	case models.QuoteDelta:
+		merged := queued
+		overlay := func(field **float64, value *float64) {
+			if value != nil {
+				*field = value
+			}
+		}
+		overlay(&merged.CurrentPrice, next.CurrentPrice)
+		overlay(&merged.Change, next.Change)
+		overlay(&merged.PercentChange, next.PercentChange)
+		overlay(&merged.High, next.High)
+		overlay(&merged.Low, next.Low)
+		overlay(&merged.Open, next.Open)
+		overlay(&merged.PreviousClose, next.PreviousClose)
+		if next.Timestamp != nil {
+			merged.Timestamp = next.Timestamp
+		}
+		if next.Provider != nil {
+			merged.Provider = next.Provider
+		}
+		if next.Stale != nil {
+			merged.Stale = next.Stale
+		}
+		return merged
+
+	default:
+		return update
+	}
+}
+
+func applyQuoteDelta(quote *models.Quote, delta models.QuoteDelta) {
+	apply := func(field *float64, value *float64) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	apply(&quote.CurrentPrice, delta.CurrentPrice)
+	apply(&quote.Change, delta.Change)
+	apply(&quote.PercentChange, delta.PercentChange)
+	apply(&quote.High, delta.High)
+	apply(&quote.Low, delta.Low)
+	apply(&quote.Open, delta.Open)
+	apply(&quote.PreviousClose, delta.PreviousClose)
+	if delta.Timestamp != nil {
+		quote.Timestamp = *delta.Timestamp
+	}
+	if delta.Provider != nil {
+		quote.Provider = *delta.Provider
+	}
+	if delta.Stale != nil {
+		quote.Stale = *delta.Stale
+	}
+}
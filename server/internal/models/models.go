@@ -13,6 +13,33 @@ type Quote struct {
 	Open             float64   `json:"o"`
 	PreviousClose    float64   `json:"pc"`
 	Timestamp        time.Time `json:"timestamp"`
+	Provider         string    `json:"provider,omitempty"`
+	Stale            bool      `json:"stale,omitempty"`
+}
+
+// QuoteDelta is a quote pushed over WebSocket carrying only the fields that
+// changed since the last quote sent to the same client. Timestamp is set
+// whenever anything else is.
+type QuoteDelta struct {
+	Symbol        string     `json:"symbol"`
+	CurrentPrice  *float64   `json:"c,omitempty"`
+	Change        *float64   `json:"d,omitempty"`
+	PercentChange *float64   `json:"dp,omitempty"`
+	High          *float64   `json:"h,omitempty"`
+	Low           *float64   `json:"l,omitempty"`
+	Open          *float64   `json:"o,omitempty"`
+	PreviousClose *float64   `json:"pc,omitempty"`
+	Timestamp     *time.Time `json:"timestamp,omitempty"`
+	Provider      *string    `json:"provider,omitempty"`
+	Stale         *bool      `json:"stale,omitempty"`
+}
+
+// Trade represents a single executed trade from a streaming feed
+type Trade struct {
+	Symbol    string    `json:"symbol"`
+	Price     float64   `json:"price"`
+	Volume    float64   `json:"volume"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // CandleData represents candlestick data
@@ -25,6 +52,8 @@ type CandleData struct {
 	Volume     []int64   `json:"v"`
 	Timestamps []int64   `json:"t"`
 	Status     string    `json:"s"`
+	Provider   string    `json:"provider,omitempty"`
+	Stale      bool      `json:"stale,omitempty"`
 }
 
 // CompanyProfile represents company information
@@ -37,6 +66,8 @@ type CompanyProfile struct {
 	ShareOutstanding      float64 `json:"shareOutstanding"`
 	Logo                  string  `json:"logo"`
 	WebURL                string  `json:"weburl"`
+	Provider              string  `json:"provider,omitempty"`
+	Stale                 bool    `json:"stale,omitempty"`
 }
 
 // NewsItem represents a news article
@@ -49,13 +80,17 @@ type NewsItem struct {
 	Image    string    `json:"image"`
 	DateTime time.Time `json:"datetime"`
 	Symbol   string    `json:"symbol"`
+	Provider string    `json:"provider,omitempty"`
+	Stale    bool      `json:"stale,omitempty"`
 }
 
 // OrderBook represents order book data
 type OrderBook struct {
-	Symbol string       `json:"symbol"`
-	Bids   []PriceLevel `json:"bids"`
-	Asks   []PriceLevel `json:"asks"`
+	Symbol   string       `json:"symbol"`
+	Bids     []PriceLevel `json:"bids"`
+	Asks     []PriceLevel `json:"asks"`
+	Provider string       `json:"provider,omitempty"`
+	Stale    bool         `json:"stale,omitempty"`
 }
 
 // PriceLevel represents a price level in the order book
@@ -64,11 +99,35 @@ type PriceLevel struct {
 	Volume int64   `json:"volume"`
 }
 
+// OrderBookUpdate is an order book pushed over WebSocket. A snapshot holds
+// the whole book; a diff holds only the levels that changed, with a volume
+// of zero for levels that were removed.
+type OrderBookUpdate struct {
+	Symbol string       `json:"symbol"`
+	Kind   string       `json:"kind"`
+	Bids   []PriceLevel `json:"bids"`
+	Asks   []PriceLevel `json:"asks"`
+}
+
+// Bar is a live one-minute candle built from trades and quotes. Time is the
+// start of the minute in Unix seconds.
+type Bar struct {
+	Symbol string  `json:"symbol"`
+	Time   int64   `json:"t"`
+	Open   float64 `json:"o"`
+	High   float64 `json:"h"`
+	Low    float64 `json:"l"`
+	Close  float64 `json:"c"`
+	Volume float64 `json:"v"`
+}
+
 // SearchResult represents a search result
 type SearchResult struct {
 	Symbol      string `json:"symbol"`
 	Description string `json:"description"`
 	Type        string `json:"type"`
+	Provider    string `json:"provider,omitempty"`
+	Stale       bool   `json:"stale,omitempty"`
 }
 
 // MarketStatus represents market status information
@@ -81,18 +140,63 @@ type MarketStatus struct {
 	Timestamp  int64  `json:"t"`
 }
 
-// WebSocketMessage represents a WebSocket message
+// WebSocketMessage represents a WebSocket message. ID echoes the request a
+// reply answers. Channel updates carry a sequence number per channel and
+// symbol; a snapshot holds the whole state as of its sequence number.
 type WebSocketMessage struct {
 	Type      string      `json:"type"`
+	ID        interface{} `json:"id,omitempty"`
+	Channel   string      `json:"channel,omitempty"`
 	Symbol    string      `json:"symbol,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Snapshot  bool        `json:"snapshot,omitempty"`
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
 }
 
-// SubscriptionMessage represents a subscription request
+// Welcome is the first message on a WebSocket connection. Format is the
+// wire format negotiated for the connection.
+type Welcome struct {
+	ClientID         string    `json:"clientId"`
+	ServerTime       time.Time `json:"serverTime"`
+	ProtocolVersion  int       `json:"protocolVersion"`
+	Format           string    `json:"format"`
+	Epoch            string    `json:"epoch"`
+	MaxSubscriptions int       `json:"maxSubscriptions"`
+}
+
+// SubscriptionMessage represents a request from a WebSocket client. Symbol
+// and Symbols may be combined; Channel defaults to quotes. ID is any string
+// or number the client wants echoed in the reply.
+//
+// A subscribe request resumes from the last sequence number the client saw
+// of the same Epoch: LastSeq for a single symbol, LastSeqs per symbol.
+// MaxRate caps the updates per second for the subscribed symbols.
 type SubscriptionMessage struct {
-	Type   string `json:"type"`
-	Symbol string `json:"symbol"`
+	Type     string            `json:"type"`
+	ID       interface{}       `json:"id,omitempty"`
+	Version  int               `json:"version,omitempty"`
+	Channel  string            `json:"channel,omitempty"`
+	Symbol   string            `json:"symbol"`
+	Symbols  []string          `json:"symbols,omitempty"`
+	Epoch    string            `json:"epoch,omitempty"`
+	LastSeq  *uint64           `json:"lastSeq,omitempty"`
+	LastSeqs map[string]uint64 `json:"lastSeqs,omitempty"`
+	MaxRate  float64           `json:"maxRate,omitempty"`
+}
+
+// SubscriptionAck acknowledges a WebSocket request. Symbols are the ones
+// the request named; for list they are every subscribed symbol and
+// Channels breaks them down by channel. Subscriptions counts channel and
+// symbol pairs. MaxRate is the update rate a subscribe was granted.
+type SubscriptionAck struct {
+	Request       string              `json:"request"`
+	Channel       string              `json:"channel,omitempty"`
+	Symbols       []string            `json:"symbols"`
+	Channels      map[string][]string `json:"channels,omitempty"`
+	Subscriptions int                 `json:"subscriptions"`
+	Limit         int                 `json:"limit"`
+	MaxRate       float64             `json:"maxRate,omitempty"`
 }
 
 // ErrorResponse represents an API error response
@@ -0,0 +1,336 @@
+package warmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"equity-server/internal/clients"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRecentErrors caps how many failures a run keeps for its status
+const maxRecentErrors = 20
+
+// SymbolSource reports which symbols are in demand, such as the WebSocket
+// hub's most subscribed symbols
+type SymbolSource interface {
+	TopSymbols(limit int) []string
+}
+
+// Options configures what a Warmer loads and when
+type Options struct {
+	// Symbols are always warmed, followed by up to TopSubscribed of the
+	// source's most subscribed symbols
+	Symbols       []string
+	TopSubscribed int
+
+	// Runs happen at startup, every Interval and at each DailyAt time
+	// ("15:04") in Location. A zero Interval and no DailyAt times only
+	// warm at startup and on demand.
+	Interval time.Duration
+	DailyAt  []string
+	Location *time.Location
+
+	// Upstream requests the warmer may make per minute, kept below the API
+	// key budget so user traffic still gets through
+	RatePerMinute int
+
+	// Days of daily candles to prefetch. Only the default span,
+	// clients.DefaultCandleDays, is what the candles endpoint serves
+	// without a from.
+	CandleDays int
+}
+
+// Status is a snapshot of the current or most recent warm run
+type Status struct {
+	Running    bool        `json:"running"`
+	Runs       int64       `json:"runs"`
+	StartedAt  time.Time   `json:"startedAt,omitempty"`
+	FinishedAt time.Time   `json:"finishedAt,omitempty"`
+	NextRunAt  time.Time   `json:"nextRunAt,omitempty"`
+	Symbols    []string    `json:"symbols"`
+	Total      int         `json:"total"`
+	Completed  int         `json:"completed"`
+	Failed     int         `json:"failed"`
+	Errors     []WarmError `json:"errors,omitempty"`
+}
+
+// WarmError is one failed prefetch
+type WarmError struct {
+	Symbol string    `json:"symbol"`
+	Data   string    `json:"data"`
+	Error  string    `json:"error"`
+	At     time.Time `json:"at"`
+}
+
+// Warmer prefetches quotes, profiles and recent daily candles through the
+// provider so the cache is warm before users arrive
+type Warmer struct {
+	provider clients.MarketDataProvider
+	source   SymbolSource
+	options  Options
+	dailyAt  []time.Time
+	limiter  *rate.Limiter
+
+	status Status
+	mutex  sync.Mutex
+
+	trigger chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// warmTask fetches one kind of data for a symbol
+type warmTask struct {
+	data  string
+	fetch func(ctx context.Context, symbol string) error
+}
+
+// New creates a warmer. source may be nil to warm only the configured
+// symbols. Malformed DailyAt times are an error.
+func New(provider clients.MarketDataProvider, source SymbolSource, options Options) (*Warmer, error) {
+	if options.Location == nil {
+		options.Location = time.UTC
+	}
+	if options.RatePerMinute <= 0 {
+		options.RatePerMinute = 30
+	}
+	if options.CandleDays <= 0 {
+		options.CandleDays = clients.DefaultCandleDays
+	}
+
+	dailyAt := make([]time.Time, 0, len(options.DailyAt))
+	for _, at := range options.DailyAt {
+		clock, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warm time %q, want HH:MM", at)
+		}
+		dailyAt = append(dailyAt, clock)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Warmer{
+		provider: provider,
+		source:   source,
+		options:  options,
+		dailyAt:  dailyAt,
+		limiter:  rate.NewLimiter(rate.Every(time.Minute/time.Duration(options.RatePerMinute)), 1),
+		trigger:  make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// Run warms the cache once, then on schedule and on demand, until Shutdown
+// is called
+func (w *Warmer) Run() {
+	defer close(w.stopped)
+
+	w.warm()
+
+	for {
+		next := w.nextRun(time.Now())
+		w.mutex.Lock()
+		w.status.NextRunAt = next
+		w.mutex.Unlock()
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-due:
+		case <-w.trigger:
+		case <-w.ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		w.warm()
+	}
+}
+
+// Shutdown cancels any run in progress and stops the schedule
+func (w *Warmer) Shutdown() {
+	w.cancel()
+	<-w.stopped
+}
+
+// Trigger queues a run. It returns false if a run is already running or
+// queued.
+func (w *Warmer) Trigger() bool {
+	w.mutex.Lock()
+	running := w.status.Running
+	w.mutex.Unlock()
+	if running {
+		return false
+	}
+
+	select {
+	case w.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns the progress of the current or most recent run
+func (w *Warmer) Status() Status {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	status := w.status
+	status.Symbols = append([]string(nil), w.status.Symbols...)
+	status.Errors = append([]WarmError(nil), w.status.Errors...)
+	return status
+}
+
+// warm prefetches every task for every symbol, paced by the limiter. A run
+// stops early if the upstream circuit opens, since the rest would fail too.
+func (w *Warmer) warm() {
+	symbols := w.symbols()
+	tasks := w.tasks()
+	start := time.Now()
+
+	w.mutex.Lock()
+	w.status = Status{
+		Running:   true,
+		Runs:      w.status.Runs + 1,
+		StartedAt: start,
+		Symbols:   symbols,
+		Total:     len(symbols) * len(tasks),
+	}
+	w.mutex.Unlock()
+
+	log.Printf("Cache warm started for %d symbols", len(symbols))
+
+	stopped := false
+	for _, symbol := range symbols {
+		for _, task := range tasks {
+			if err := w.limiter.Wait(w.ctx); err != nil {
+				stopped = true
+				break
+			}
+
+			err := task.fetch(w.ctx, symbol)
+			w.record(symbol, task.data, err)
+			if errors.Is(err, clients.ErrCircuitOpen) {
+				log.Printf("Cache warm stopped early: %v", err)
+				stopped = true
+				break
+			}
+		}
+		if stopped {
+			break
+		}
+	}
+
+	w.mutex.Lock()
+	w.status.Running = false
+	w.status.FinishedAt = time.Now()
+	status := w.status
+	w.mutex.Unlock()
+
+	log.Printf("Cache warm finished: %d of %d fetched, %d failed in %v",
+		status.Completed, status.Total, status.Failed, time.Since(start).Round(time.Millisecond))
+}
+
+func (w *Warmer) record(symbol, data string, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err == nil {
+		w.status.Completed++
+		return
+	}
+
+	w.status.Failed++
+	if len(w.status.Errors) == maxRecentErrors {
+		w.status.Errors = w.status.Errors[1:]
+	}
+	w.status.Errors = append(w.status.Errors, WarmError{
+		Symbol: symbol,
+		Data:   data,
+		Error:  err.Error(),
+		At:     time.Now(),
+	})
+}
+
+// symbols returns the configured symbols followed by the most subscribed
+// ones, without duplicates
+func (w *Warmer) symbols() []string {
+	candidates := w.options.Symbols
+	if w.source != nil && w.options.TopSubscribed > 0 {
+		candidates = append(append([]string(nil), candidates...), w.source.TopSymbols(w.options.TopSubscribed)...)
+	}
+
+	seen := make(map[string]bool)
+	symbols := make([]string, 0, len(candidates))
+	for _, symbol := range candidates {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// tasks lists what is prefetched per symbol
+func (w *Warmer) tasks() []warmTask {
+	return []warmTask{
+		{data: "quote", fetch: func(ctx context.Context, symbol string) error {
+			_, err := w.provider.GetQuote(ctx, symbol)
+			return err
+		}},
+		{data: "profile", fetch: func(ctx context.Context, symbol string) error {
+			_, err := w.provider.GetProfile(ctx, symbol)
+			return err
+		}},
+		{data: "candles", fetch: func(ctx context.Context, symbol string) error {
+			to := time.Now()
+			from := to.AddDate(0, 0, -w.options.CandleDays)
+			_, err := w.provider.GetCandles(ctx, symbol, "D", from.Unix(), to.Unix())
+			return err
+		}},
+	}
+}
+
+// nextRun returns when the next scheduled run is due after now, or the zero
+// time if nothing is scheduled
+func (w *Warmer) nextRun(now time.Time) time.Time {
+	var next time.Time
+	if w.options.Interval > 0 {
+		next = now.Add(w.options.Interval)
+	}
+
+	local := now.In(w.options.Location)
+	for _, clock := range w.dailyAt {
+		at := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, w.options.Location)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	return next
+}